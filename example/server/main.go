@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"sync/atomic"
 
 	pb "github.com/FlorinBalint/flo_lb/proto"
 	"google.golang.org/protobuf/proto"
@@ -19,8 +21,42 @@ var (
 	name          = flag.String("name", "Server", "Name of the service")
 	registerURL   = flag.String("register_url", "", "URL for registering to the load balancer")
 	deregisterURL = flag.String("deregister_url", "", "URL for registering to the load balancer")
+	weight        = flag.Int("weight", 0, "Relative share of traffic to ask for when registering, 0 means default")
+	baseLoad      = flag.Float64("base_load", 0.2, "Synthetic CPU and memory usage to report, between 0 and 1")
 )
 
+// Header through which the load is reported to the load balancer.
+const loadHeader = "X-Backend-Load"
+
+var inFlight int32
+
+func clamp(usage float64) float64 {
+	if usage < 0 {
+		return 0
+	} else if usage > 1 {
+		return 1
+	}
+	return usage
+}
+
+// syntheticLoad makes up a load report, growing with the requests in flight.
+func syntheticLoad() string {
+	queue := atomic.LoadInt32(&inFlight)
+	cpu := clamp(*baseLoad + 0.05*float64(queue) + 0.1*(rand.Float64()-0.5))
+	mem := clamp(*baseLoad + 0.02*float64(queue))
+	return fmt.Sprintf("cpu=%.2f, mem=%.2f, queue=%d", cpu, mem, queue)
+}
+
+// reportLoad publishes the synthetic load on every response.
+func reportLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		w.Header().Set(loadHeader, syntheticLoad())
+		next.ServeHTTP(w, r)
+	})
+}
+
 func getRoot(w http.ResponseWriter, r *http.Request) {
 	log.Printf("got / request\n")
 	io.WriteString(w, "This is my website!\n")
@@ -35,13 +71,16 @@ func getHello(w http.ResponseWriter, r *http.Request) {
 func iAmAlive(w http.ResponseWriter, r *http.Request) {
 	log.Printf("got /healthz request\n")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	w.Write([]byte(syntheticLoad()))
 }
 
 func registerRequest() (*http.Request, error) {
 	bodyReq := &pb.RegisterRequest{
 		Port: proto.Int32(int32(*port)),
 	}
+	if *weight > 0 {
+		bodyReq.Weight = proto.Int32(int32(*weight))
+	}
 
 	if len(*hostOverride) != 0 {
 		bodyReq.Host = hostOverride
@@ -121,7 +160,7 @@ func main() {
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%v", *port),
-		Handler: mux,
+		Handler: reportLoad(mux),
 	}
 
 	srv.RegisterOnShutdown(deregisterIfNeeded)
@@ -1,6 +1,6 @@
 module github.com/FlorinBalint/flo_lb
 
-go 1.18
+go 1.20
 
 require (
 	github.com/FlorinBalint/flo_lb/proto v0.1.0
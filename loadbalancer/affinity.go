@@ -0,0 +1,221 @@
+package loadbalancer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const defaultAffinityCookie = "flo_lb_affinity"
+const defaultAffinityTTL = time.Hour
+
+// backendIDSize is the size in bytes of the backend IDs in the cookies.
+const backendIDSize = 16
+
+// backendLookup is implemented by the algorithms that can find
+// a registered backend by its URL.
+type backendLookup interface {
+	Lookup(rawURL string) *algos.Backend
+}
+
+// stickySessions pins each client to the backend of its first request,
+// through a cookie that holds an opaque ID of the backend, an expiry and
+// a signature. Requests without a valid cookie, or whose backend is gone
+// or not ready, are balanced by the wrapped algorithm and get a new cookie.
+type stickySessions struct {
+	lbAlgorithm
+	cookieName string
+	ttl        time.Duration
+	key        []byte
+	now        func() time.Time
+
+	mu sync.RWMutex
+	// urls maps the IDs of the registered backends to their URLs.
+	urls map[string]string
+}
+
+func newStickySessions(algo lbAlgorithm, beCfg *pb.BackendConfig) (*stickySessions, error) {
+	if _, ok := algo.(backendLookup); !ok {
+		return nil, fmt.Errorf("the balancing algorithm does not support session affinity")
+	}
+
+	cfg := beCfg.GetSessionAffinity()
+	sticky := &stickySessions{
+		lbAlgorithm: algo,
+		cookieName:  cfg.GetCookieName(),
+		ttl:         defaultAffinityTTL,
+		key:         []byte(cfg.GetSigningKey()),
+		now:         time.Now,
+		urls:        make(map[string]string),
+	}
+	if len(sticky.cookieName) == 0 {
+		sticky.cookieName = defaultAffinityCookie
+	}
+	if cfg.GetTtl() != nil {
+		sticky.ttl = cfg.GetTtl().AsDuration()
+	}
+	if len(sticky.key) == 0 {
+		log.Printf("No session affinity signing key set, sessions will not survive restarts")
+		sticky.key = make([]byte, 32)
+		if _, err := rand.Read(sticky.key); err != nil {
+			return nil, fmt.Errorf("error generating a signing key: %v", err)
+		}
+	}
+	for _, rawURL := range beCfg.GetStatic().GetUrls() {
+		sticky.addBackend(rawURL)
+	}
+	for _, weighted := range beCfg.GetStatic().GetWeightedUrls() {
+		sticky.addBackend(weighted.GetUrl())
+	}
+	return sticky, nil
+}
+
+// backendID returns the ID of a backend in the cookies, a keyed hash of
+// its URL so that the cookies do not reveal the backend addresses.
+func (s *stickySessions) backendID(rawURL string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("backend:" + rawURL))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:backendIDSize])
+}
+
+func (s *stickySessions) addBackend(rawURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urls[s.backendID(rawURL)] = rawURL
+}
+
+func (s *stickySessions) removeBackend(rawURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.urls, s.backendID(rawURL))
+}
+
+func (s *stickySessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookieValue holds the backend ID and the expiry, followed by their signature.
+func (s *stickySessions) cookieValue(rawURL string) string {
+	payload := s.backendID(rawURL) + "." + strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+	return payload + "." + s.sign(payload)
+}
+
+// pinnedURL returns the backend URL of a request with a valid, unexpired
+// cookie of a registered backend.
+func (s *stickySessions) pinnedURL(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(s.cookieName)
+	if err != nil {
+		return "", false
+	}
+	dot := strings.LastIndex(cookie.Value, ".")
+	if dot < 0 {
+		return "", false
+	}
+	payload, signature := cookie.Value[:dot], cookie.Value[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return "", false
+	}
+
+	id, rawExpiry, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(rawExpiry, 10, 64)
+	if err != nil || s.now().Unix() >= expiry {
+		return "", false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rawURL, ok := s.urls[id]
+	return rawURL, ok
+}
+
+func (s *stickySessions) Handler(r *http.Request) http.Handler {
+	if rawURL, ok := s.pinnedURL(r); ok {
+		if be := s.Lookup(rawURL); be != nil {
+			if handler, ready := be.GetOpenConnection(r); ready {
+				return handler
+			}
+		}
+	}
+
+	handler := s.lbAlgorithm.Handler(r)
+	be := algos.BackendOf(handler)
+	if be == nil {
+		return handler
+	}
+	return &stickyHandler{sessions: s, be: be, next: handler}
+}
+
+// stickyHandler pins the client to the backend it proxies to
+// by setting the affinity cookie on the response.
+type stickyHandler struct {
+	sessions *stickySessions
+	be       *algos.Backend
+	next     http.Handler
+}
+
+func (sh *stickyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := sh.sessions
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookieName,
+		Value:    s.cookieValue(sh.be.URL()),
+		Path:     "/",
+		MaxAge:   int(s.ttl.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	sh.next.ServeHTTP(w, r)
+}
+
+// Backend returns the backend the client is pinned to.
+func (sh *stickyHandler) Backend() *algos.Backend {
+	return sh.be
+}
+
+func (s *stickySessions) Register(rawURL string) error {
+	if err := s.lbAlgorithm.Register(rawURL); err != nil {
+		return err
+	}
+	s.addBackend(rawURL)
+	return nil
+}
+
+// RegisterWeighted keeps the weights working for the wrapped algorithm.
+func (s *stickySessions) RegisterWeighted(rawURL string, weight int32) error {
+	weighted, ok := s.lbAlgorithm.(weightedRegistrar)
+	if !ok {
+		return s.Register(rawURL)
+	}
+	if err := weighted.RegisterWeighted(rawURL, weight); err != nil {
+		return err
+	}
+	s.addBackend(rawURL)
+	return nil
+}
+
+func (s *stickySessions) Deregister(rawURL string) error {
+	if err := s.lbAlgorithm.Deregister(rawURL); err != nil {
+		return err
+	}
+	s.removeBackend(rawURL)
+	return nil
+}
+
+func (s *stickySessions) Lookup(rawURL string) *algos.Backend {
+	return s.lbAlgorithm.(backendLookup).Lookup(rawURL)
+}
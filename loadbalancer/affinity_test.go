@@ -0,0 +1,193 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestAffinityCookie(t *testing.T) {
+	rr, err := algos.NewRoundRobin(&pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	beCfg := &pb.BackendConfig{
+		Type: &pb.BackendConfig_Static{Static: &pb.StaticBackends{Urls: []string{"http://backend:8081"}}},
+		SessionAffinity: &pb.SessionAffinity{
+			SigningKey: proto.String("secret"),
+			Ttl:        durationpb.New(time.Minute),
+		},
+	}
+	sticky, err := newStickySessions(rr, beCfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	now := time.Unix(1655562339, 0)
+	sticky.now = func() time.Time { return now }
+	valid := sticky.cookieValue("http://backend:8081")
+	if strings.Contains(valid, "backend") || strings.Contains(valid, "aHR0cDovL2JhY2tlbmQ6ODA4MQ") {
+		t.Errorf("want a cookie not revealing the backend, got %v", valid)
+	}
+
+	otherKey, _ := newStickySessions(rr, &pb.BackendConfig{
+		Type:            beCfg.Type,
+		SessionAffinity: &pb.SessionAffinity{SigningKey: proto.String("other")},
+	})
+	tampered := []byte(valid)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name    string
+		value   string
+		elapsed time.Duration
+		wantURL string
+		wantOK  bool
+	}{
+		{
+			name:    "Valid cookie",
+			value:   valid,
+			wantURL: "http://backend:8081",
+			wantOK:  true,
+		},
+		{
+			name:    "Expired cookie",
+			value:   valid,
+			elapsed: time.Minute,
+		},
+		{
+			name:  "Tampered cookie",
+			value: string(tampered),
+		},
+		{
+			name:  "Unknown backend",
+			value: sticky.cookieValue("http://other:8081"),
+		},
+		{
+			name:  "Cookie signed with another key",
+			value: otherKey.cookieValue("http://backend:8081"),
+		},
+		{
+			name:  "Garbage cookie",
+			value: "not-a-cookie",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sticky.now = func() time.Time { return now.Add(test.elapsed) }
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: defaultAffinityCookie, Value: test.value})
+			gotURL, gotOK := sticky.pinnedURL(req)
+			if gotOK != test.wantOK || gotURL != test.wantURL {
+				t.Errorf("pinnedURL() want (%q, %v), got (%q, %v)", test.wantURL, test.wantOK, gotURL, gotOK)
+			}
+		})
+	}
+}
+
+func TestStickySessions(t *testing.T) {
+	backends := []*testBackend{alwaysAliveBackend(), alwaysAliveBackend()}
+	for _, be := range backends {
+		be.startListen(t)
+		defer be.stop(t)
+	}
+	tc := &testCase{backends: backends}
+	beCfg := tc.backendCfg()
+	beCfg.SessionAffinity = &pb.SessionAffinity{SigningKey: proto.String("secret")}
+	lb, err := New(&pb.Config{Backend: beCfg})
+	if err != nil {
+		t.Fatalf("error creating LB: %v", err)
+	}
+	lookup := lb.defaultPool.lbAlgo.(backendLookup)
+	for _, be := range backends {
+		lookup.Lookup(be.server.URL).SetAlive(true)
+	}
+
+	serve := func(cookie *http.Cookie) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	affinityCookie := func(resp *http.Response) *http.Cookie {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == defaultAffinityCookie {
+				return cookie
+			}
+		}
+		return nil
+	}
+	pinnedBackend := func() int {
+		for i, be := range backends {
+			if atomic.LoadInt32(&be.requestsReceived) > 0 {
+				return i
+			}
+		}
+		return -1
+	}
+
+	cookie := affinityCookie(serve(nil))
+	if cookie == nil {
+		t.Fatalf("want an affinity cookie on the first response, got none")
+	}
+	pinned := pinnedBackend()
+	for i := 0; i < 4; i++ {
+		if resp := serve(cookie); affinityCookie(resp) != nil {
+			t.Errorf("want no new cookie for a pinned request, got %v", affinityCookie(resp))
+		}
+	}
+	if got := atomic.LoadInt32(&backends[pinned].requestsReceived); got != 5 {
+		t.Errorf("pinned backend got %v requests, want 5", got)
+	}
+
+	// Once the pinned backend dies, the client moves to the other one
+	lookup.Lookup(backends[pinned].server.URL).SetAlive(false)
+	other := 1 - pinned
+	newCookie := affinityCookie(serve(cookie))
+	if newCookie == nil {
+		t.Fatalf("want a new affinity cookie after failing over, got none")
+	}
+	serve(newCookie)
+	if got := atomic.LoadInt32(&backends[other].requestsReceived); got != 2 {
+		t.Errorf("failover backend got %v requests, want 2", got)
+	}
+}
+
+func TestAffinityCookieOfDeregisteredBackend(t *testing.T) {
+	rr, err := algos.NewRoundRobin(&pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sticky, err := newStickySessions(rr, &pb.BackendConfig{
+		SessionAffinity: &pb.SessionAffinity{SigningKey: proto.String("secret")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := sticky.Register("http://backend:8081"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultAffinityCookie, Value: sticky.cookieValue("http://backend:8081")})
+	if _, ok := sticky.pinnedURL(req); !ok {
+		t.Fatalf("want the cookie of a registered backend valid")
+	}
+
+	if err := sticky.Deregister("http://backend:8081"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if rawURL, ok := sticky.pinnedURL(req); ok {
+		t.Errorf("want no backend for the cookie of a deregistered one, got %v", rawURL)
+	}
+}
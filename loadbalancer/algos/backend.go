@@ -1,31 +1,81 @@
 package algos
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
 )
 
 const aliveMask int32 = 0x0001
 const readyMask int32 = 0x0002
 const aliveAndReady int32 = aliveMask | readyMask
 
+// latencyDecay is the weight of the newest sample in the moving average
+// of response times.
+const latencyDecay = 0.3
+
+// latencyHalfLife is how long it takes for the moving average of response
+// times to halve without new samples, so that a backend avoided for being
+// slow gets tried again.
+const latencyHalfLife = 10 * time.Second
+
+// failureLatency is the response time at least counted for the failed
+// requests, which are often fast and would make a failing backend look
+// like the fastest one.
+const failureLatency = time.Second
+
 type Backend struct {
-	rawURL      string
-	url         *url.URL
-	connections []http.Handler
-	status      int32
-	mu          sync.RWMutex
+	// The int64 fields are kept first so they are 64-bit aligned for atomics.
+	// inFlight counts the proxied requests that did not finish yet.
+	inFlight int64
+	// The traffic since the last TakeTrafficStats, for the outlier detection.
+	requests            int64
+	failures            int64
+	consecutiveFailures int64
+	latencySum          int64
+	latencyCount        int64
+	// ejectedUntil is the time in nanoseconds until which the backend
+	// is ejected by the outlier detection.
+	ejectedUntil int64
+
+	rawURL string
+	url    *url.URL
+	status int32
+	weight int32
+	load   *LoadReport
+	// pool configures the transport of the proxy, which is created once,
+	// on the first request, and reused so its connections are kept alive.
+	pool      *pb.ConnectionPool
+	proxy     *httputil.ReverseProxy
+	proxyOnce sync.Once
+	// inFlightChanged, when set, is called after each change of the
+	// in-flight count, letting the algorithm owning the backend know its
+	// order is out of date.
+	inFlightChanged func()
+	// breaker is nil if the backend has no circuit breaker.
+	breaker *CircuitBreaker
+	mu      sync.RWMutex
+
+	// latency is an exponentially weighted moving average of the response
+	// times, as of latencyObserved.
+	latencyMu       sync.Mutex
+	latency         time.Duration
+	latencyObserved time.Time
 }
 
 type UnavailableHandler struct{}
 
 func (UnavailableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusServiceUnavailable)
-	w.Write([]byte("No available service\n"))
+	WriteError(w, r, http.StatusServiceUnavailable, "No available service\n")
 }
 
 func (b *Backend) String() string {
@@ -37,6 +87,12 @@ func (b *Backend) URL() string {
 }
 
 func NewBackend(rawURL string) (*Backend, error) {
+	return NewBackendWithPool(rawURL, nil)
+}
+
+// NewBackendWithPool creates a backend whose connections follow the pool
+// config, nil means the default limits.
+func NewBackendWithPool(rawURL string, pool *pb.ConnectionPool) (*Backend, error) {
 	actualUrl, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, err
@@ -46,13 +102,29 @@ func NewBackend(rawURL string) (*Backend, error) {
 		rawURL: rawURL,
 		status: readyMask, // TODO: Implement readiness checks
 		url:    actualUrl,
+		pool:   pool,
 	}, nil
 }
 
+// NewBackendWithConfig creates a backend with the connection pool and
+// circuit breaker of beCfg.
+func NewBackendWithConfig(rawURL string, beCfg *pb.BackendConfig) (*Backend, error) {
+	be, err := NewBackendWithPool(rawURL, beCfg.GetConnectionPool())
+	if err != nil {
+		return nil, err
+	}
+	if beCfg.GetCircuitBreaker() != nil {
+		if be.breaker, err = NewCircuitBreaker(rawURL, beCfg.GetCircuitBreaker()); err != nil {
+			return nil, err
+		}
+	}
+	return be, nil
+}
+
 func (b *Backend) andMaskStatus(mask int32) {
 	for {
-		newStatus := atomic.LoadInt32(&b.status) & mask
-		if atomic.CompareAndSwapInt32(&b.status, b.status, newStatus) {
+		status := atomic.LoadInt32(&b.status)
+		if atomic.CompareAndSwapInt32(&b.status, status, status&mask) {
 			break
 		}
 	}
@@ -60,13 +132,26 @@ func (b *Backend) andMaskStatus(mask int32) {
 
 func (b *Backend) orMaskStatus(mask int32) {
 	for {
-		newStatus := atomic.LoadInt32(&b.status) | mask
-		if atomic.CompareAndSwapInt32(&b.status, b.status, newStatus) {
+		status := atomic.LoadInt32(&b.status)
+		if atomic.CompareAndSwapInt32(&b.status, status, status|mask) {
 			break
 		}
 	}
 }
 
+// Weight returns the relative share of traffic the backend should get.
+func (b *Backend) Weight() int32 {
+	if w := atomic.LoadInt32(&b.weight); w > 0 {
+		return w
+	}
+	return 1
+}
+
+// SetWeight changes the share of traffic, non positive weights mean the default 1.
+func (b *Backend) SetWeight(weight int32) {
+	atomic.StoreInt32(&b.weight, weight)
+}
+
 func (b *Backend) SetAlive(alive bool) {
 	if alive {
 		b.orMaskStatus(aliveMask)
@@ -91,33 +176,200 @@ func (b *Backend) IsReady() bool {
 	return atomic.LoadInt32(&b.status)&readyMask > 0
 }
 
+// IsAliveAndReady reports if the backend can be picked, which also needs
+// it not to be ejected and its circuit breaker, if any, to let requests through.
 func (b *Backend) IsAliveAndReady() bool {
-	return atomic.LoadInt32(&b.status) == aliveAndReady
+	return atomic.LoadInt32(&b.status) == aliveAndReady && b.selectable()
 }
 
-func (b *Backend) ConnectionsCount() int {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	return len(b.connections)
+// availableFor reports if the backend can be picked for r, which
+// also needs r not to exclude it.
+func (b *Backend) availableFor(r *http.Request) bool {
+	return !excluded(r)[b] && b.IsAliveAndReady()
 }
 
-func (b *Backend) openConnection() http.Handler {
+func (b *Backend) selectable() bool {
+	return !b.IsEjected(time.Now()) && (b.breaker == nil || b.breaker.allows())
+}
+
+// BreakerState returns the state of the circuit breaker of the backend,
+// closed if it has none.
+func (b *Backend) BreakerState() BreakerState {
+	if b.breaker == nil {
+		return BreakerClosed
+	}
+	return b.breaker.State()
+}
+
+// Latency returns the moving average of the times the backend took to send
+// the response headers, or 0 if no request was proxied to it yet. Failed requests count as taking
+// at least failureLatency, and the average goes down while no request ends.
+func (b *Backend) Latency() time.Duration {
+	b.latencyMu.Lock()
+	defer b.latencyMu.Unlock()
+	return b.latencyAt(time.Now())
+}
+
+// latencyAt returns the moving average decayed until now.
+// b.latencyMu must be held.
+func (b *Backend) latencyAt(now time.Time) time.Duration {
+	if b.latency == 0 {
+		return 0
+	}
+	halvings := float64(now.Sub(b.latencyObserved)) / float64(latencyHalfLife)
+	return time.Duration(float64(b.latency) * math.Exp2(-halvings))
+}
+
+func (b *Backend) observeLatency(d time.Duration, now time.Time) {
+	b.latencyMu.Lock()
+	defer b.latencyMu.Unlock()
+	if old := b.latencyAt(now); old != 0 {
+		d = old + time.Duration(latencyDecay*float64(d-old))
+	}
+	b.latency, b.latencyObserved = d, now
+}
+
+// ReportLoad records the latest resource usage reported by the backend.
+func (b *Backend) ReportLoad(report LoadReport) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	reverseProxy := httputil.NewSingleHostReverseProxy(b.url)
-	// TODO(#7): Check when we close a connection
-	b.connections = append(b.connections, reverseProxy)
-	return reverseProxy
+	b.load = &report
 }
 
-func (b *Backend) GetOpenConnection(_ *http.Request) (http.Handler, bool) {
-	// TODO(#7): Open connection based on stickiness config.
+// Load returns the latest resource usage reported by the backend,
+// or false if the backend never reported it.
+func (b *Backend) Load() (LoadReport, bool) {
 	b.mu.RLock()
-	if b.status != aliveAndReady {
-		b.mu.RUnlock()
-		return nil, false
+	defer b.mu.RUnlock()
+	if b.load == nil {
+		return LoadReport{}, false
+	}
+	return *b.load, true
+}
+
+// ConnectionsCount returns the number of requests currently proxied to the backend.
+func (b *Backend) ConnectionsCount() int {
+	return int(atomic.LoadInt64(&b.inFlight))
+}
+
+func (b *Backend) addInFlight(delta int64) {
+	atomic.AddInt64(&b.inFlight, delta)
+	if changed := b.inFlightChanged; changed != nil {
+		changed()
+	}
+}
+
+func (b *Backend) reverseProxy() *httputil.ReverseProxy {
+	b.proxyOnce.Do(func() {
+		b.proxy = httputil.NewSingleHostReverseProxy(b.url)
+		b.proxy.Transport = newTransport(b.pool)
+		b.proxy.ModifyResponse = b.modifyResponse
+		b.proxy.ErrorHandler = b.proxyError
+	})
+	return b.proxy
+}
+
+// closeIdleConnections closes the connections kept alive to the backend,
+// for when it is deregistered and no new requests will reuse them.
+func (b *Backend) closeIdleConnections() {
+	if tr, ok := b.reverseProxy().Transport.(interface{ CloseIdleConnections() }); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+type requestStartKey struct{}
+
+// observeResponse records how long the backend took to answer the request
+// started at start, counting a failure as taking at least failureLatency
+// in the moving average.
+func (b *Backend) observeResponse(r *http.Request, success bool) {
+	start, ok := r.Context().Value(requestStartKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(start)
+	b.recordLatency(elapsed)
+	if !success && elapsed < failureLatency {
+		b.observeLatency(failureLatency, now)
+	} else {
+		b.observeLatency(elapsed, now)
+	}
+}
+
+// modifyResponse handles the responses of the backend before they are
+// passed on, counting the 5xx statuses as failures. The latency is the
+// time to the response headers, upgraded connections have none.
+func (b *Backend) modifyResponse(resp *http.Response) error {
+	success := resp.StatusCode < 500
+	b.recordOutcome(success)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		b.observeResponse(resp.Request, success)
+	}
+	return b.readLoadHeader(resp)
+}
+
+// proxyError counts the failed requests, except the ones canceled
+// by their clients, before answering them. The requests canceled or
+// out of time do not say how fast the backend is.
+func (b *Backend) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, context.Canceled) {
+		b.recordOutcome(false)
+	} else if b.breaker != nil {
+		b.breaker.release()
+	}
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		b.observeResponse(r, false)
+	}
+	proxyErrorHandler(w, r, err)
+}
+
+func (b *Backend) openConnection() http.Handler {
+	return &trackedHandler{be: b, next: b.reverseProxy()}
+}
+
+// trackedHandler proxies a request to a backend, counting it as in flight
+// until the response is done. It marks when the request started, for the
+// proxy to record how long the backend took to answer.
+type trackedHandler struct {
+	be   *Backend
+	next http.Handler
+}
+
+func (th *trackedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(context.WithValue(r.Context(), requestStartKey{}, time.Now()))
+	th.be.addInFlight(1)
+	// The reverse proxy only returns once streamed or upgraded (hijacked)
+	// responses are done, but it panics on aborted requests, so use a defer.
+	defer th.be.addInFlight(-1)
+	th.next.ServeHTTP(w, r)
+}
+
+// Backend returns the backend the handler proxies to.
+func (th *trackedHandler) Backend() *Backend {
+	return th.be
+}
+
+// BackendOf returns the backend a handler from GetOpenConnection proxies to,
+// also through the handlers wrapping it that have a Backend method, or nil
+// for other handlers, like UnavailableHandler.
+func BackendOf(h http.Handler) *Backend {
+	if bh, ok := h.(interface{ Backend() *Backend }); ok {
+		return bh.Backend()
 	}
+	return nil
+}
 
-	b.mu.RUnlock()
+// GetOpenConnection returns a handler proxying to the backend, or false if
+// it cannot be picked for r. When the circuit breaker is half open, the
+// handler holds one of its probe slots, given back once the request ends,
+// so it must be served.
+func (b *Backend) GetOpenConnection(r *http.Request) (http.Handler, bool) {
+	if atomic.LoadInt32(&b.status) != aliveAndReady || excluded(r)[b] {
+		return nil, false
+	}
+	if b.IsEjected(time.Now()) || (b.breaker != nil && !b.breaker.tryAcquire()) {
+		return nil, false
+	}
 	return b.openConnection(), true
 }
@@ -1,10 +1,13 @@
 package algos
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestSetAlive(t *testing.T) {
@@ -123,7 +126,6 @@ func TestGetOpenConnection(t *testing.T) {
 			url:    test.url,
 			status: test.mask,
 			// TODO(#7): Test reuse connection based on stickiness config.
-			connections: []http.Handler{test.existing},
 		}
 
 		con, ok := be.GetOpenConnection(nil)
@@ -138,3 +140,195 @@ func TestGetOpenConnection(t *testing.T) {
 		}
 	}
 }
+
+func TestObserveLatency(t *testing.T) {
+	be := &Backend{}
+	if be.Latency() != 0 {
+		t.Errorf("be.Latency() want 0 before any request, got %v", be.Latency())
+	}
+
+	now := time.Now()
+	be.observeLatency(100*time.Millisecond, now)
+	if got := be.latencyAt(now); got != 100*time.Millisecond {
+		t.Errorf("be.latencyAt() want first sample %v, got %v", 100*time.Millisecond, got)
+	}
+
+	be.observeLatency(200*time.Millisecond, now)
+	want := 130 * time.Millisecond // 100ms + 0.3 * (200ms - 100ms)
+	if got := be.latencyAt(now); got != want {
+		t.Errorf("be.latencyAt() want %v, got %v", want, got)
+	}
+
+	// Without new samples, the average halves every half life.
+	if got := be.latencyAt(now.Add(2 * latencyHalfLife)); got != want/4 {
+		t.Errorf("be.latencyAt() two half lives later want %v, got %v", want/4, got)
+	}
+}
+
+func TestLatencyIsTimeToHeaders(t *testing.T) {
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer streaming.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		timeout time.Duration
+		wantMax time.Duration
+	}{
+		{name: "Streamed body", url: streaming.URL, wantMax: 150 * time.Millisecond},
+		{name: "Request out of time", url: slow.URL, timeout: 5 * time.Millisecond, wantMax: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			be := aliveBackendAt(t, test.url)
+			handler, ok := be.GetOpenConnection(nil)
+			if !ok {
+				t.Fatalf("backend.GetOpenConnection() want non nil, got nil")
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.timeout > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), test.timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got := be.Latency(); got > test.wantMax {
+				t.Errorf("be.Latency() want at most %v, got %v", test.wantMax, got)
+			}
+		})
+	}
+}
+
+func TestInFlightTracking(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	backendSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush() // stream the headers, keep the body open
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer backendSrv.Close()
+
+	be, err := NewBackend(backendSrv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	be.SetAlive(true)
+	handler, ok := be.GetOpenConnection(nil)
+	if !ok {
+		t.Fatalf("backend.GetOpenConnection() want non nil, got nil")
+	}
+	if got := be.ConnectionsCount(); got != 0 {
+		t.Errorf("ConnectionsCount() before serving want 0, got %v", got)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-started
+	if got := be.ConnectionsCount(); got != 1 {
+		t.Errorf("ConnectionsCount() while streaming want 1, got %v", got)
+	}
+	close(release)
+	<-done
+	if got := be.ConnectionsCount(); got != 0 {
+		t.Errorf("ConnectionsCount() after the response want 0, got %v", got)
+	}
+}
+
+func TestInFlightTrackingOnAbort(t *testing.T) {
+	be := &Backend{}
+	th := &trackedHandler{
+		be: be,
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := be.ConnectionsCount(); got != 1 {
+				t.Errorf("ConnectionsCount() while serving want 1, got %v", got)
+			}
+			panic(http.ErrAbortHandler) // what the reverse proxy does when the client goes away
+		}),
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Errorf("want http.ErrAbortHandler panic, got %v", r)
+			}
+		}()
+		th.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if got := be.ConnectionsCount(); got != 0 {
+		t.Errorf("ConnectionsCount() after an aborted request want 0, got %v", got)
+	}
+}
+
+func TestProxyIsReused(t *testing.T) {
+	be, err := NewBackend("http://localhost:8080")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if first, second := be.reverseProxy(), be.reverseProxy(); first != second {
+		t.Errorf("want the same proxy for every request, got %p and %p", first, second)
+	}
+}
+
+func benchmarkServer(b *testing.B) *httptest.Server {
+	b.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+}
+
+// BenchmarkProxyPerRequest proxies the way backends used to: a new reverse
+// proxy per request, over the default transport that keeps 2 idle
+// connections per host, so parallel requests keep dialing new ones.
+func BenchmarkProxyPerRequest(b *testing.B) {
+	srv := benchmarkServer(b)
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+	})
+}
+
+// BenchmarkBackendProxy proxies through the long lived proxy of a backend.
+func BenchmarkBackendProxy(b *testing.B) {
+	srv := benchmarkServer(b)
+	defer srv.Close()
+	be, err := NewBackend(srv.URL)
+	if err != nil {
+		b.Fatalf("unexpected error %v", err)
+	}
+	be.SetAlive(true)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			handler, _ := be.GetOpenConnection(nil)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package algos
+
+import (
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// backendSet keeps the registered backends in registration order and
+// allows looking them up by URL. It is not safe for concurrent use,
+// callers must hold their own lock.
+type backendSet struct {
+	backends []*Backend
+	indices  map[string]int
+}
+
+func newBackendSet(backends []*Backend) *backendSet {
+	set := &backendSet{
+		indices: make(map[string]int),
+	}
+	for _, be := range backends {
+		set.add(be)
+	}
+	return set
+}
+
+// staticBackends creates the backends listed in the static config, if any.
+func staticBackends(beCfg *pb.BackendConfig) ([]*Backend, error) {
+	var backends []*Backend
+	for _, rawURL := range beCfg.GetStatic().GetUrls() {
+		be, err := NewBackendWithConfig(rawURL, beCfg)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, be)
+	}
+	for _, weighted := range beCfg.GetStatic().GetWeightedUrls() {
+		be, err := NewBackendWithConfig(weighted.GetUrl(), beCfg)
+		if err != nil {
+			return nil, err
+		}
+		be.SetWeight(weighted.GetWeight())
+		backends = append(backends, be)
+	}
+	return backends, nil
+}
+
+// add appends be to the set, returns false if its URL was already present.
+func (set *backendSet) add(be *Backend) bool {
+	if _, present := set.indices[be.URL()]; present {
+		return false
+	}
+	set.indices[be.URL()] = len(set.backends)
+	set.backends = append(set.backends, be)
+	return true
+}
+
+// remove deletes the backend with the given URL and closes its idle
+// connections, returns false if it is unknown.
+func (set *backendSet) remove(rawURL string) bool {
+	idx, present := set.indices[rawURL]
+	if !present {
+		return false
+	}
+	set.backends[idx].closeIdleConnections()
+	set.backends = append(set.backends[:idx], set.backends[idx+1:]...)
+	delete(set.indices, rawURL)
+	for i := idx; i < len(set.backends); i++ {
+		set.indices[set.backends[i].URL()] = i
+	}
+	return true
+}
+
+func (set *backendSet) get(rawURL string) *Backend {
+	if idx, present := set.indices[rawURL]; present {
+		return set.backends[idx]
+	}
+	return nil
+}
+
+func (set *backendSet) size() int {
+	return len(set.backends)
+}
+
+// values returns a copy of the backends, safe to use after releasing the lock.
+func (set *backendSet) values() []*Backend {
+	res := make([]*Backend, len(set.backends))
+	copy(res, set.backends)
+	return res
+}
@@ -0,0 +1,223 @@
+package algos
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultBreakerMinRequests  = 20
+	defaultBreakerWindow       = 10 * time.Second
+	defaultBreakerCooldown     = 30 * time.Second
+	defaultHalfOpenRequests    = 1
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int32
+
+const (
+	// BreakerClosed lets all the requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen lets no request through until the cooldown passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe requests through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops the requests to a backend failing too often,
+// for a cooldown, then probes it with a few requests before closing.
+type CircuitBreaker struct {
+	name                string
+	consecutiveFailures int
+	// errorRate is 0 if only the consecutive failures open the breaker.
+	errorRate   float64
+	minRequests int64
+	cooldown    time.Duration
+	halfOpen    int
+	now         func() time.Time
+
+	mu    sync.Mutex
+	state BreakerState
+	// failures counts the failures in a row while closed.
+	failures int
+	openedAt time.Time
+	// probes counts the probe requests in flight while half open,
+	// and succeeded the probes that succeeded.
+	probes    int
+	succeeded int
+	// window counts the requests and failures for the error rate.
+	window *WindowCounter
+}
+
+// NewCircuitBreaker creates a closed breaker, name is used in the logs.
+func NewCircuitBreaker(name string, breakerCfg *pb.CircuitBreaker) (*CircuitBreaker, error) {
+	cb := &CircuitBreaker{
+		name:                name,
+		consecutiveFailures: defaultConsecutiveFailures,
+		minRequests:         defaultBreakerMinRequests,
+		cooldown:            defaultBreakerCooldown,
+		halfOpen:            defaultHalfOpenRequests,
+		now:                 time.Now,
+	}
+	if breakerCfg.ConsecutiveFailures != nil {
+		if breakerCfg.GetConsecutiveFailures() < 1 {
+			return nil, fmt.Errorf("circuit breaker consecutive failures must be at least 1, got %v",
+				breakerCfg.GetConsecutiveFailures())
+		}
+		cb.consecutiveFailures = int(breakerCfg.GetConsecutiveFailures())
+	}
+	if breakerCfg.ErrorRate != nil {
+		if breakerCfg.GetErrorRate() <= 0 || breakerCfg.GetErrorRate() > 1 {
+			return nil, fmt.Errorf("circuit breaker error rate must be in (0, 1], got %v", breakerCfg.GetErrorRate())
+		}
+		cb.errorRate = breakerCfg.GetErrorRate()
+	}
+	if breakerCfg.MinRequests != nil {
+		cb.minRequests = int64(breakerCfg.GetMinRequests())
+	}
+	window := defaultBreakerWindow
+	if breakerCfg.GetWindow() != nil {
+		window = breakerCfg.GetWindow().AsDuration()
+		if window < time.Second {
+			return nil, fmt.Errorf("circuit breaker window must be at least 1s, got %v", window)
+		}
+	}
+	cb.window = NewWindowCounter(window)
+	if breakerCfg.GetCooldown() != nil {
+		cb.cooldown = breakerCfg.GetCooldown().AsDuration()
+	}
+	if breakerCfg.HalfOpenRequests != nil {
+		if breakerCfg.GetHalfOpenRequests() < 1 {
+			return nil, fmt.Errorf("circuit breaker half open requests must be at least 1, got %v",
+				breakerCfg.GetHalfOpenRequests())
+		}
+		cb.halfOpen = int(breakerCfg.GetHalfOpenRequests())
+	}
+	return cb, nil
+}
+
+// transition changes the state, resetting the counts. cb.mu must be held.
+func (cb *CircuitBreaker) transition(state BreakerState) {
+	log.Printf("Circuit breaker of %v is now %v", cb.name, state)
+	cb.state = state
+	cb.failures, cb.probes, cb.succeeded = 0, 0, 0
+	cb.window.Reset()
+	if state == BreakerOpen {
+		cb.openedAt = cb.now()
+	}
+}
+
+// stateNow returns the state, moving from open to half open once the
+// cooldown passed. cb.mu must be held.
+func (cb *CircuitBreaker) stateNow() BreakerState {
+	if cb.state == BreakerOpen && cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		cb.transition(BreakerHalfOpen)
+	}
+	return cb.state
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.stateNow()
+}
+
+// allows reports if the breaker lets a new request through, without
+// taking a probe slot, see tryAcquire.
+func (cb *CircuitBreaker) allows() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.stateNow() {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return cb.probes+cb.succeeded < cb.halfOpen
+	default:
+		return true
+	}
+}
+
+// tryAcquire reports if the breaker lets a new request through, taking
+// one of the probe slots when half open so that concurrent requests
+// cannot exceed them.
+func (cb *CircuitBreaker) tryAcquire() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.stateNow() {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if cb.probes+cb.succeeded >= cb.halfOpen {
+			return false
+		}
+		cb.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// release gives back the probe slot of a request ended without an
+// outcome, like one canceled by its client.
+func (cb *CircuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == BreakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// record counts the outcome of a request, opening or closing the breaker.
+func (cb *CircuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.stateNow() {
+	case BreakerHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		if !success {
+			cb.transition(BreakerOpen)
+		} else if cb.succeeded++; cb.succeeded >= cb.halfOpen {
+			cb.transition(BreakerClosed)
+		}
+	case BreakerClosed:
+		if success {
+			cb.window.Add(cb.now(), 1, 0)
+			cb.failures = 0
+			return
+		}
+		cb.window.Add(cb.now(), 1, 1)
+		cb.failures++
+		if cb.failures >= cb.consecutiveFailures || cb.errorRateExceeded() {
+			cb.transition(BreakerOpen)
+		}
+	}
+}
+
+// errorRateExceeded reports if the failures in the window reached the
+// error rate. cb.mu must be held.
+func (cb *CircuitBreaker) errorRateExceeded() bool {
+	if cb.errorRate == 0 {
+		return false
+	}
+	requests, failed := cb.window.Sums(cb.now())
+	return requests >= cb.minRequests && float64(failed) >= cb.errorRate*float64(requests)
+}
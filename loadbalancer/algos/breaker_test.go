@@ -0,0 +1,206 @@
+package algos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newTestBreaker(t *testing.T, breakerCfg *pb.CircuitBreaker) (*CircuitBreaker, *time.Time) {
+	t.Helper()
+	cb, err := NewCircuitBreaker("test", breakerCfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	now := time.Unix(1000, 0)
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	cb, now := newTestBreaker(t, &pb.CircuitBreaker{
+		ConsecutiveFailures: proto.Int32(3),
+		Cooldown:            durationpb.New(30 * time.Second),
+		HalfOpenRequests:    proto.Int32(2),
+	})
+
+	cb.record(false)
+	cb.record(false)
+	cb.record(true)
+	cb.record(false)
+	cb.record(false)
+	if got := cb.State(); got != BreakerClosed {
+		t.Fatalf("want %v after a success between failures, got %v", BreakerClosed, got)
+	}
+	cb.record(false)
+	if got := cb.State(); got != BreakerOpen {
+		t.Fatalf("want %v after 3 failures in a row, got %v", BreakerOpen, got)
+	}
+	if cb.allows() {
+		t.Errorf("want an open breaker to deny requests")
+	}
+
+	*now = now.Add(30 * time.Second)
+	if got := cb.State(); got != BreakerHalfOpen {
+		t.Fatalf("want %v after the cooldown, got %v", BreakerHalfOpen, got)
+	}
+	for i := 0; i < 2; i++ {
+		if !cb.tryAcquire() {
+			t.Fatalf("want probe %v allowed", i+1)
+		}
+	}
+	if cb.allows() {
+		t.Errorf("want no more than 2 probes in flight")
+	}
+	cb.record(true)
+	if cb.allows() {
+		t.Errorf("want no new probe while the other one is in flight")
+	}
+	cb.record(true)
+	if got := cb.State(); got != BreakerClosed {
+		t.Errorf("want %v after the probes succeeded, got %v", BreakerClosed, got)
+	}
+}
+
+func TestCircuitBreakerProbeFailure(t *testing.T) {
+	cb, now := newTestBreaker(t, &pb.CircuitBreaker{ConsecutiveFailures: proto.Int32(1)})
+
+	cb.record(false)
+	*now = now.Add(defaultBreakerCooldown)
+	cb.tryAcquire()
+	cb.release()
+	if !cb.tryAcquire() {
+		t.Fatalf("want a probe allowed after a canceled one")
+	}
+	cb.record(false)
+	if got := cb.State(); got != BreakerOpen {
+		t.Errorf("want %v after a failed probe, got %v", BreakerOpen, got)
+	}
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	cb, now := newTestBreaker(t, &pb.CircuitBreaker{
+		ConsecutiveFailures: proto.Int32(100),
+		ErrorRate:           proto.Float64(0.5),
+		MinRequests:         proto.Int32(10),
+		Window:              durationpb.New(10 * time.Second),
+	})
+
+	for i := 0; i < 9; i++ {
+		cb.record(false)
+	}
+	if got := cb.State(); got != BreakerClosed {
+		t.Fatalf("want %v below the min requests, got %v", BreakerClosed, got)
+	}
+	// The old failures left the window.
+	*now = now.Add(11 * time.Second)
+	cb.record(false)
+	if got := cb.State(); got != BreakerClosed {
+		t.Fatalf("want %v once the failures left the window, got %v", BreakerClosed, got)
+	}
+	for i := 0; i < 5; i++ {
+		cb.record(true)
+	}
+	for i := 0; i < 4; i++ {
+		cb.record(false)
+	}
+	if got := cb.State(); got != BreakerOpen {
+		t.Errorf("want %v at a 50%% error rate, got %v", BreakerOpen, got)
+	}
+}
+
+func TestBackendWithBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	be, err := NewBackendWithConfig(server.URL, &pb.BackendConfig{
+		CircuitBreaker: &pb.CircuitBreaker{ConsecutiveFailures: proto.Int32(2)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	be.SetAlive(true)
+
+	for i := 0; i < 2; i++ {
+		handler, ok := be.GetOpenConnection(nil)
+		if !ok {
+			t.Fatalf("want backend ready before request %v", i+1)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if be.IsAliveAndReady() {
+		t.Errorf("want backend with an open breaker not ready")
+	}
+	if _, ok := be.GetOpenConnection(nil); ok {
+		t.Errorf("want no connection to a backend with an open breaker")
+	}
+	if !be.IsAlive() || !be.IsReady() {
+		t.Errorf("want the breaker to leave the health status alone")
+	}
+	if got := be.BreakerState(); got != BreakerOpen {
+		t.Errorf("want breaker %v, got %v", BreakerOpen, got)
+	}
+}
+
+func TestHalfOpenBreakerLimitsConcurrentProbes(t *testing.T) {
+	be, err := NewBackendWithConfig("http://localhost", &pb.BackendConfig{
+		CircuitBreaker: &pb.CircuitBreaker{
+			ConsecutiveFailures: proto.Int32(1),
+			Cooldown:            durationpb.New(0),
+			HalfOpenRequests:    proto.Int32(1),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	be.SetAlive(true)
+	be.breaker.record(false)
+	if got := be.BreakerState(); got != BreakerHalfOpen {
+		t.Fatalf("want %v, got %v", BreakerHalfOpen, got)
+	}
+
+	var wg sync.WaitGroup
+	var acquired int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := be.GetOpenConnection(nil); ok {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired != 1 {
+		t.Errorf("want 1 probe through the half open breaker, got %v", acquired)
+	}
+}
+
+func TestNewCircuitBreakerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		breaker *pb.CircuitBreaker
+	}{
+		{name: "No failures", breaker: &pb.CircuitBreaker{ConsecutiveFailures: proto.Int32(0)}},
+		{name: "Error rate above 1", breaker: &pb.CircuitBreaker{ErrorRate: proto.Float64(1.5)}},
+		{name: "Short window", breaker: &pb.CircuitBreaker{Window: durationpb.New(time.Millisecond)}},
+		{name: "No probes", breaker: &pb.CircuitBreaker{HalfOpenRequests: proto.Int32(0)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewCircuitBreaker("test", test.breaker); err == nil {
+				t.Errorf("NewCircuitBreaker() want error, got nil")
+			}
+		})
+	}
+}
@@ -6,7 +6,9 @@ import (
 )
 
 type Checker struct {
-	fn         func(context.Context, *Backend)
+	fn func(context.Context, *Backend)
+	// poolFn, when set, checks all the backends at once instead of fn.
+	poolFn     func(context.Context, []*Backend)
 	period     time.Duration
 	beSupplier func() []*Backend
 }
@@ -18,6 +20,15 @@ func NewChecker(fn func(context.Context, *Backend), period time.Duration) *Check
 	}
 }
 
+// NewPoolChecker creates a checker looking at all the backends at once,
+// for checks comparing them with each other.
+func NewPoolChecker(fn func(context.Context, []*Backend), period time.Duration) *Checker {
+	return &Checker{
+		poolFn: fn,
+		period: period,
+	}
+}
+
 func (chk *Checker) runInBackground(ctx context.Context) {
 	go func() {
 		t := time.NewTicker(chk.period)
@@ -25,9 +36,13 @@ func (chk *Checker) runInBackground(ctx context.Context) {
 		for true {
 			select {
 			case <-ctx.Done():
-				break
+				return
 			case <-t.C:
-				// range makes a slice copy, so changing backends is safe
+				if chk.poolFn != nil {
+					go chk.poolFn(ctx, chk.beSupplier())
+					continue
+				}
+				// The suppliers return a copy, so changing backends is safe
 				for _, be := range chk.beSupplier() {
 					go chk.fn(ctx, be)
 				}
@@ -0,0 +1,198 @@
+package algos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const defaultVirtualNodes = 160
+
+type ringPoint struct {
+	hash uint64
+	be   *Backend
+}
+
+// ConsistentHash places every backend on a hash ring multiple times (its
+// virtual nodes), then sends a request to the first backend found clockwise
+// from the hash of the request key. Adding or removing one of N backends
+// only moves about 1/N of the keys, which keeps per backend caches warm.
+//
+// With a load factor ε set, backends are also capped at (1+ε) times the
+// average requests in flight, so a hot key cannot overload its backend:
+// requests over the cap keep walking the ring, as described in
+// "Consistent Hashing with Bounded Loads" (Mirrokni et al.).
+type ConsistentHash struct {
+	backends     *backendSet
+	ring         []ringPoint
+	virtualNodes int
+	loadFactor   float64
+	key          keyFunc
+	beCfg        *pb.BackendConfig
+	mu           sync.RWMutex
+}
+
+func newConsistentHashWithBackends(backends []*Backend, beCfg *pb.BackendConfig) (*ConsistentHash, error) {
+	key, err := newKeyFunc(beCfg.GetHashPolicy())
+	if err != nil {
+		return nil, err
+	}
+	virtualNodes := int(beCfg.GetConsistentHash().GetVirtualNodes())
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	ch := &ConsistentHash{
+		backends:     newBackendSet(backends),
+		virtualNodes: virtualNodes,
+		loadFactor:   beCfg.GetConsistentHash().GetLoadFactor(),
+		key:          key,
+		beCfg:        beCfg,
+	}
+	ch.rebuildRing()
+	return ch, nil
+}
+
+func NewConsistentHash(beCfg *pb.BackendConfig) (*ConsistentHash, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newConsistentHashWithBackends(backends, beCfg)
+}
+
+// rebuildRing recomputes the ring from the backends, callers must hold the write lock.
+func (ch *ConsistentHash) rebuildRing() {
+	ring := make([]ringPoint, 0, ch.backends.size()*ch.virtualNodes)
+	for _, be := range ch.backends.backends {
+		for i := 0; i < ch.virtualNodes; i++ {
+			ring = append(ring, ringPoint{
+				hash: hashString(fmt.Sprintf("%v#%d", be.URL(), i)),
+				be:   be,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	ch.ring = ring
+}
+
+func (ch *ConsistentHash) Register(rawURL string) error {
+	newBe, err := NewBackendWithConfig(rawURL, ch.beCfg)
+	if err != nil {
+		return err
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !ch.backends.add(newBe) {
+		log.Printf("%v already registered", rawURL)
+		return nil
+	}
+	ch.rebuildRing()
+	return nil
+}
+
+func (ch *ConsistentHash) Deregister(rawURL string) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !ch.backends.remove(rawURL) {
+		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
+	}
+	ch.rebuildRing()
+	return nil
+}
+
+// ringIndex returns the position of the first point at or after hash,
+// callers must hold the read lock.
+func (ch *ConsistentHash) ringIndex(hash uint64) int {
+	idx := sort.Search(len(ch.ring), func(i int) bool {
+		return ch.ring[i].hash >= hash
+	})
+	if idx == len(ch.ring) {
+		return 0 // wrap around the ring
+	}
+	return idx
+}
+
+// loadCap returns how many requests in flight a backend may have with
+// bounded loads, callers must hold the read lock.
+func (ch *ConsistentHash) loadCap() int {
+	total, alive := 0, 0
+	for _, be := range ch.backends.backends {
+		if be.IsAliveAndReady() {
+			total += be.ConnectionsCount()
+			alive++
+		}
+	}
+	if alive == 0 {
+		return 0
+	}
+	// The request being placed counts towards the average too.
+	return int(math.Ceil((1 + ch.loadFactor) * float64(total+1) / float64(alive)))
+}
+
+// backendFor walks the ring clockwise from hash, skipping the backends
+// that are not alive and ready or that r excludes, and the ones that are
+// full when loads are bounded.
+func (ch *ConsistentHash) backendFor(r *http.Request, hash uint64) *Backend {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if len(ch.ring) == 0 {
+		return nil
+	}
+
+	bounded := ch.loadFactor > 0
+	maxLoad := 0
+	if bounded {
+		maxLoad = ch.loadCap()
+	}
+	var firstAlive *Backend
+	start := ch.ringIndex(hash)
+	for i := 0; i < len(ch.ring); i++ {
+		be := ch.ring[(start+i)%len(ch.ring)].be
+		if !be.availableFor(r) {
+			continue
+		}
+		if !bounded || be.ConnectionsCount() < maxLoad {
+			return be
+		}
+		if firstAlive == nil {
+			firstAlive = be
+		}
+	}
+	// Not reachable with a consistent view of the loads, since some backend
+	// is always under the average, but loads change while we walk the ring.
+	return firstAlive
+}
+
+func (ch *ConsistentHash) nextBackend(r *http.Request) *Backend {
+	return ch.backendFor(r, hashString(ch.key(r)))
+}
+
+func (ch *ConsistentHash) Handler(r *http.Request) http.Handler {
+	return openConnection(r, ch.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (ch *ConsistentHash) Lookup(rawURL string) *Backend {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	return ch.backends.get(rawURL)
+}
+
+func (ch *ConsistentHash) RegisterCheck(ctx context.Context, chk *Checker) {
+	chk.beSupplier = func() []*Backend {
+		ch.mu.RLock()
+		defer ch.mu.RUnlock()
+		return ch.backends.values()
+	}
+
+	chk.runInBackground(ctx)
+}
@@ -0,0 +1,182 @@
+package algos
+
+import (
+	"fmt"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+const testKeys = 10000
+
+func keyOwners(ch *ConsistentHash) map[string]*Backend {
+	owners := make(map[string]*Backend, testKeys)
+	for i := 0; i < testKeys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = ch.backendFor(nil, hashString(key))
+	}
+	return owners
+}
+
+func TestConsistentHashSpreadsKeys(t *testing.T) {
+	backends := aliveBackends(t, 5)
+	ch, err := newConsistentHashWithBackends(backends, &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	counts := make(map[*Backend]int)
+	for _, be := range keyOwners(ch) {
+		counts[be]++
+	}
+	for _, be := range backends {
+		// With 160 virtual nodes each backend stays well within 30% of its fair share
+		if share := counts[be]; share < testKeys/5*7/10 || share > testKeys/5*13/10 {
+			t.Errorf("%v got %v keys, want about %v", be, share, testKeys/5)
+		}
+	}
+}
+
+func TestConsistentHashRegisterMovesFewKeys(t *testing.T) {
+	ch, err := newConsistentHashWithBackends(aliveBackends(t, 10), &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	before := keyOwners(ch)
+
+	newURL := "http://backend-new:8080"
+	if err := ch.Register(newURL); err != nil {
+		t.Fatalf("unexpected error registering: %v", err)
+	}
+	newBE := ch.backends.get(newURL)
+	newBE.SetAlive(true)
+
+	moved := 0
+	for key, be := range keyOwners(ch) {
+		if be == before[key] {
+			continue
+		}
+		moved++
+		if be != newBE {
+			t.Errorf("key %v moved from %v to %v, want only moves to the new backend", key, before[key], be)
+		}
+	}
+	// The new backend should take about 1/11 of the keys
+	if moved < testKeys/22 || moved > testKeys*2/11 {
+		t.Errorf("%v keys moved, want about %v", moved, testKeys/11)
+	}
+}
+
+func TestConsistentHashDeregisterMovesOnlyItsKeys(t *testing.T) {
+	backends := aliveBackends(t, 10)
+	ch, err := newConsistentHashWithBackends(backends, &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	before := keyOwners(ch)
+
+	removed := backends[3]
+	if err := ch.Deregister(removed.URL()); err != nil {
+		t.Fatalf("unexpected error deregistering: %v", err)
+	}
+
+	for key, be := range keyOwners(ch) {
+		if before[key] != removed && be != before[key] {
+			t.Errorf("key %v moved from %v to %v, want it to stay", key, before[key], be)
+		}
+		if be == removed {
+			t.Errorf("key %v still on the removed backend", key)
+		}
+	}
+}
+
+func TestConsistentHashSkipsUnready(t *testing.T) {
+	backends := aliveBackends(t, 3)
+	ch, err := newConsistentHashWithBackends(backends, &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	before := keyOwners(ch)
+
+	dead := backends[0]
+	dead.SetAlive(false)
+	for key, be := range keyOwners(ch) {
+		if be == dead {
+			t.Errorf("key %v went to the dead backend", key)
+		} else if before[key] != dead && be != before[key] {
+			t.Errorf("key %v moved from %v to %v, want it to stay", key, before[key], be)
+		}
+	}
+
+	for _, be := range backends {
+		be.SetAlive(false)
+	}
+	if be := ch.backendFor(nil, hashString("user-1")); be != nil {
+		t.Errorf("want nil with no alive backends, got %v", be)
+	}
+}
+
+func withConnections(be *Backend, count int) *Backend {
+	be.inFlight = int64(count)
+	return be
+}
+
+func TestConsistentHashBoundedLoads(t *testing.T) {
+	backends := aliveBackends(t, 4)
+	cfg := &pb.BackendConfig{
+		ConsistentHash: &pb.ConsistentHashConfig{
+			LoadFactor: proto.Float64(0.25),
+		},
+	}
+	ch, err := newConsistentHashWithBackends(backends, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	hotKey := hashString("hot-user")
+	owner := ch.backendFor(nil, hotKey)
+
+	// 12 requests in flight, the cap is ceil(1.25 * 13 / 4) = 5
+	for _, be := range backends {
+		withConnections(be, 2)
+	}
+	withConnections(owner, 6)
+	got := ch.backendFor(nil, hotKey)
+	if got == owner {
+		t.Errorf("want the full owner %v skipped, got it", owner)
+	}
+
+	// The request lands on the next backend clockwise that has room
+	start := ch.ringIndex(hotKey)
+	var want *Backend
+	for i := 0; i < len(ch.ring); i++ {
+		if be := ch.ring[(start+i)%len(ch.ring)].be; be != owner {
+			want = be
+			break
+		}
+	}
+	if got != want {
+		t.Errorf("want next backend on the ring %v, got %v", want, got)
+	}
+
+	// Under the cap, the owner keeps its key
+	withConnections(owner, 3) // the cap is ceil(1.25 * 10 / 4) = 4
+	if got := ch.backendFor(nil, hotKey); got != owner {
+		t.Errorf("want owner %v under the cap, got %v", owner, got)
+	}
+}
+
+func TestConsistentHashUnboundedIgnoresLoad(t *testing.T) {
+	backends := aliveBackends(t, 4)
+	ch, err := newConsistentHashWithBackends(backends, &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	hotKey := hashString("hot-user")
+	owner := ch.backendFor(nil, hotKey)
+	withConnections(owner, 100)
+	if got := ch.backendFor(nil, hotKey); got != owner {
+		t.Errorf("want owner %v without a load factor, got %v", owner, got)
+	}
+}
@@ -0,0 +1,57 @@
+package algos
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+)
+
+type errorWriterKey struct{}
+
+// ErrorWriter writes the response of an error generated by the load
+// balancer itself, like when no backend is available. defaultBody is
+// what the load balancer answers when no error page is configured.
+type ErrorWriter func(w http.ResponseWriter, r *http.Request, status int, defaultBody string)
+
+// WithErrorWriter returns a copy of r whose load balancer errors
+// are written by ew.
+func WithErrorWriter(r *http.Request, ew ErrorWriter) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), errorWriterKey{}, ew))
+}
+
+// WriteError answers r with an error status, through the ErrorWriter
+// of r if it has one, or with defaultBody as plain text otherwise.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, defaultBody string) {
+	if ew, ok := r.Context().Value(errorWriterKey{}).(ErrorWriter); ok {
+		ew(w, r, status, defaultBody)
+		return
+	}
+	if len(defaultBody) > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(defaultBody))
+}
+
+// isTimeout reports if err comes from a timeout, as opposed
+// to a refused or broken connection.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// proxyErrorHandler answers the requests that could not be proxied
+// with a 504 on timeouts and a 502 otherwise.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("http: proxy error: %v", err)
+	status := http.StatusBadGateway
+	if isTimeout(err) {
+		status = http.StatusGatewayTimeout
+	}
+	WriteError(w, r, status, "")
+}
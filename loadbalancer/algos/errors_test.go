@@ -0,0 +1,51 @@
+package algos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	resp := httptest.NewRecorder()
+	WriteError(resp, req, http.StatusServiceUnavailable, "No available service\n")
+	if resp.Code != http.StatusServiceUnavailable || resp.Body.String() != "No available service\n" {
+		t.Errorf("want the default error, got %v %q", resp.Code, resp.Body.String())
+	}
+
+	var gotStatus int
+	req = WithErrorWriter(req, func(w http.ResponseWriter, r *http.Request, status int, defaultBody string) {
+		gotStatus = status
+		w.WriteHeader(status)
+		w.Write([]byte("custom"))
+	})
+	resp = httptest.NewRecorder()
+	WriteError(resp, req, http.StatusBadGateway, "")
+	if gotStatus != http.StatusBadGateway || resp.Body.String() != "custom" {
+		t.Errorf("want the error writer to answer 502, got %v %q", gotStatus, resp.Body.String())
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: context.DeadlineExceeded, want: true},
+		{err: fmt.Errorf("wrapped: %w", context.DeadlineExceeded), want: true},
+		{err: os.ErrDeadlineExceeded, want: true},
+		{err: context.Canceled, want: false},
+		{err: errors.New("connection refused"), want: false},
+	}
+
+	for _, test := range tests {
+		if got := isTimeout(test.err); got != test.want {
+			t.Errorf("isTimeout(%v) want %v, got %v", test.err, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package algos
+
+import (
+	"context"
+	"net/http"
+)
+
+type excludedKey struct{}
+
+// WithExcluded returns a copy of r for which the algorithms do not pick
+// the excluded backends, e.g. the ones a retried request already failed on.
+// When only excluded backends are left, the algorithms answer with an
+// UnavailableHandler right away instead of backing off.
+func WithExcluded(r *http.Request, excluded map[*Backend]bool) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), excludedKey{}, excluded))
+}
+
+// excluded returns the backends r excludes, nil if none.
+func excluded(r *http.Request) map[*Backend]bool {
+	if r == nil {
+		return nil
+	}
+	set, _ := r.Context().Value(excludedKey{}).(map[*Backend]bool)
+	return set
+}
+
+// hasExclusions reports if r excludes any backend.
+func hasExclusions(r *http.Request) bool {
+	return len(excluded(r)) > 0
+}
+
+// openConnection opens a connection to the backend next picks for r. If
+// the backend cannot be picked anymore, e.g. another request took the last
+// probe slot of its circuit breaker since next checked it, it picks again
+// with that backend excluded, until next finds no backend.
+func openConnection(r *http.Request, next func(r *http.Request) *Backend) http.Handler {
+	var failed map[*Backend]bool
+	for {
+		be := next(r)
+		if be == nil {
+			return UnavailableHandler{}
+		}
+		if res, ok := be.GetOpenConnection(r); ok {
+			return res
+		}
+		if failed == nil {
+			// The exclusions of r belong to the caller, they are not changed.
+			failed = make(map[*Backend]bool)
+			for excludedBE := range excluded(r) {
+				failed[excludedBE] = true
+			}
+		}
+		failed[be] = true
+		r = WithExcluded(r, failed)
+	}
+}
@@ -0,0 +1,55 @@
+package algos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenConnection(t *testing.T) {
+	down := backendWithStatus(t, aliveMask, 0)
+	up := upAndReadyBackend(t, 1)
+	tests := []struct {
+		name     string
+		backends []*Backend
+		want     *Backend
+	}{
+		{
+			name:     "First pick opens",
+			backends: []*Backend{up, down},
+			want:     up,
+		},
+		{
+			name:     "Picks again without the failed backend",
+			backends: []*Backend{down, up},
+			want:     up,
+		},
+		{
+			name:     "No backend opens",
+			backends: []*Backend{down},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// next ignores the state of the backends, as if they changed
+			// between the pick and opening the connection.
+			next := func(r *http.Request) *Backend {
+				for _, be := range test.backends {
+					if !excluded(r)[be] {
+						return be
+					}
+				}
+				return nil
+			}
+			tried := map[*Backend]bool{}
+			req := WithExcluded(httptest.NewRequest("GET", "/", nil), tried)
+			if got := BackendOf(openConnection(req, next)); got != test.want {
+				t.Errorf("want backend %v, got %v", test.want, got)
+			}
+			if len(tried) != 0 {
+				t.Errorf("want the exclusions of the request unchanged, got %v", tried)
+			}
+		})
+	}
+}
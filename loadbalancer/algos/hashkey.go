@@ -0,0 +1,153 @@
+package algos
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strings"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// keyFunc extracts the part of a request that is hashed to pick a backend.
+type keyFunc func(r *http.Request) string
+
+func newKeyFunc(policy *pb.HashPolicy) (keyFunc, error) {
+	switch policy.GetSource() {
+	case pb.HashPolicy_HEADER:
+		if len(policy.GetName()) == 0 {
+			return nil, fmt.Errorf("hashing by header requires a header name")
+		}
+		header := http.CanonicalHeaderKey(policy.GetName())
+		return func(r *http.Request) string {
+			if value := r.Header.Get(header); len(value) != 0 {
+				return value
+			}
+			return clientIP(r)
+		}, nil
+	case pb.HashPolicy_COOKIE:
+		if len(policy.GetName()) == 0 {
+			return nil, fmt.Errorf("hashing by cookie requires a cookie name")
+		}
+		name := policy.GetName()
+		return func(r *http.Request) string {
+			if cookie, err := r.Cookie(name); err == nil && len(cookie.Value) != 0 {
+				return cookie.Value
+			}
+			return clientIP(r)
+		}, nil
+	case pb.HashPolicy_PATH:
+		return func(r *http.Request) string {
+			return r.URL.Path
+		}, nil
+	case pb.HashPolicy_FORWARDED_FOR:
+		trusted, err := parseTrustedProxies(policy.GetTrustedProxies())
+		if err != nil {
+			return nil, err
+		}
+		return trusted.clientIP, nil
+	default:
+		return clientIP, nil
+	}
+}
+
+// NewRequestHash returns a function hashing the key picked by policy
+// from a request, so equal keys always get the same hash.
+func NewRequestHash(policy *pb.HashPolicy) (func(r *http.Request) uint64, error) {
+	key, err := newKeyFunc(policy)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) uint64 {
+		return hashString(key(r))
+	}, nil
+}
+
+// clientIP returns the address of the peer, without the port.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// trustedProxies are the peers whose X-Forwarded-For header is believed.
+type trustedProxies []*net.IPNet
+
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	var res trustedProxies
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %v", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %v: %v", proxy, err)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+func (tp trustedProxies) contains(rawIP string) bool {
+	ip := net.ParseIP(rawIP)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range tp {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client behind the trusted proxies:
+// the X-Forwarded-For hops are walked from the closest one and the first
+// hop that is not a trusted proxy is returned. Clients can put anything in
+// the header, so it is ignored when the peer itself is not trusted.
+func (tp trustedProxies) clientIP(r *http.Request) string {
+	client := clientIP(r)
+	if !tp.contains(client) {
+		return client
+	}
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break // a malformed hop, trust nothing before it
+		}
+		client = hop
+		if !tp.contains(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// hashString hashes s with FNV-1a, then mixes the bits with the MurmurHash3
+// finalizer, since FNV alone spreads similar strings poorly over the ring.
+func hashString(s string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(s))
+	h := hasher.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
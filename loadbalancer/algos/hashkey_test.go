@@ -0,0 +1,140 @@
+package algos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestKeyFunc(t *testing.T) {
+	withHeader := httptest.NewRequest("GET", "/users/42", nil)
+	withHeader.RemoteAddr = "10.0.0.1:5123"
+	withHeader.Header.Set("X-User", "alice")
+	withHeader.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	plain := httptest.NewRequest("GET", "/users/7", nil)
+	plain.RemoteAddr = "10.0.0.2:6000"
+
+	proxied := httptest.NewRequest("GET", "/", nil)
+	proxied.RemoteAddr = "192.168.1.10:443"
+	proxied.Header.Add("X-Forwarded-For", "6.6.6.6, 203.0.113.7")
+	proxied.Header.Add("X-Forwarded-For", "192.168.1.3")
+
+	spoofed := httptest.NewRequest("GET", "/", nil)
+	spoofed.RemoteAddr = "198.51.100.4:5000"
+	spoofed.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	forwardedFor := &pb.HashPolicy{
+		Source:         pb.HashPolicy_FORWARDED_FOR.Enum(),
+		TrustedProxies: []string{"192.168.1.0/24", "10.0.0.1"},
+	}
+
+	tests := []struct {
+		name   string
+		policy *pb.HashPolicy
+		req    *http.Request
+		want   string
+	}{
+		{
+			name:   "Default hashes the client IP",
+			policy: nil,
+			req:    withHeader,
+			want:   "10.0.0.1",
+		},
+		{
+			name: "Header value",
+			policy: &pb.HashPolicy{
+				Source: pb.HashPolicy_HEADER.Enum(),
+				Name:   proto.String("x-user"),
+			},
+			req:  withHeader,
+			want: "alice",
+		},
+		{
+			name: "Missing header falls back to client IP",
+			policy: &pb.HashPolicy{
+				Source: pb.HashPolicy_HEADER.Enum(),
+				Name:   proto.String("X-User"),
+			},
+			req:  plain,
+			want: "10.0.0.2",
+		},
+		{
+			name: "Cookie value",
+			policy: &pb.HashPolicy{
+				Source: pb.HashPolicy_COOKIE.Enum(),
+				Name:   proto.String("session"),
+			},
+			req:  withHeader,
+			want: "abc",
+		},
+		{
+			name: "Missing cookie falls back to client IP",
+			policy: &pb.HashPolicy{
+				Source: pb.HashPolicy_COOKIE.Enum(),
+				Name:   proto.String("session"),
+			},
+			req:  plain,
+			want: "10.0.0.2",
+		},
+		{
+			name: "URL path",
+			policy: &pb.HashPolicy{
+				Source: pb.HashPolicy_PATH.Enum(),
+			},
+			req:  plain,
+			want: "/users/7",
+		},
+		{
+			name:   "First hop outside the trusted proxies",
+			policy: forwardedFor,
+			req:    proxied,
+			want:   "203.0.113.7",
+		},
+		{
+			name:   "Untrusted peers cannot set the client IP",
+			policy: forwardedFor,
+			req:    spoofed,
+			want:   "198.51.100.4",
+		},
+		{
+			name:   "Trusted peer without the header",
+			policy: forwardedFor,
+			req:    withHeader,
+			want:   "10.0.0.1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := newKeyFunc(test.policy)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got := key(test.req); got != test.want {
+				t.Errorf("key() want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestKeyFuncRequiresName(t *testing.T) {
+	for _, source := range []pb.HashPolicy_Source{pb.HashPolicy_HEADER, pb.HashPolicy_COOKIE} {
+		if _, err := newKeyFunc(&pb.HashPolicy{Source: source.Enum()}); err == nil {
+			t.Errorf("newKeyFunc(%v) without name want error, got nil", source)
+		}
+	}
+}
+
+func TestKeyFuncInvalidTrustedProxy(t *testing.T) {
+	policy := &pb.HashPolicy{
+		Source:         pb.HashPolicy_FORWARDED_FOR.Enum(),
+		TrustedProxies: []string{"not-an-ip"},
+	}
+	if _, err := newKeyFunc(policy); err == nil {
+		t.Errorf("newKeyFunc() with an invalid trusted proxy want error, got nil")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/FlorinBalint/flo_lb/proto"
@@ -21,19 +22,16 @@ func (beComparator) Less(a, b *Backend) bool {
 type LeastConnections struct {
 	backends *AdressablePQ[string, *Backend]
 	backoff  *Backoff
-	mu       sync.RWMutex
+	beCfg    *pb.BackendConfig
+	// stale is 1 when the in-flight counts changed since the heap was
+	// last ordered, accessed atomically.
+	stale int32
+	mu    sync.RWMutex
 }
 
 func newLeastConnsWithbackends(backends []*Backend) (*LeastConnections, error) {
-	bePQ := NewPQWithComparator[string, *Backend](beComparator{})
-	for _, be := range backends {
-		// Note: This normally takes O(n) in our case because
-		// each element keeps its position (no need for a smart BuildHeap).
-		bePQ.Push(be.URL(), be)
-	}
-
-	return &LeastConnections{
-		backends: bePQ,
+	lConn := &LeastConnections{
+		backends: NewPQWithComparator[string, *Backend](beComparator{}),
 		// TODO consider making all these (and max backoffs) configurable
 		backoff: NewBackoff(
 			300*time.Millisecond, // initial sleep
@@ -41,34 +39,58 @@ func newLeastConnsWithbackends(backends []*Backend) (*LeastConnections, error) {
 			10*time.Second,       // sleep time reset
 			2.0,                  // growth factor
 		),
-	}, nil
+	}
+	for _, be := range backends {
+		// Note: This normally takes O(n) in our case because
+		// each element keeps its position (no need for a smart BuildHeap).
+		be.inFlightChanged = lConn.markStale
+		lConn.backends.Push(be.URL(), be)
+	}
+	return lConn, nil
 }
 
-func NewLeastConnections(beCfg *pb.BackendConfig) (*LeastConnections, error) {
-	var rawURLs []string
-	if beCfg.GetStatic() != nil {
-		rawURLs = beCfg.GetStatic().GetUrls()
+// markStale records that the heap order is out of date, so it is
+// repaired by the next pick instead of locking on every request.
+func (lConn *LeastConnections) markStale() {
+	atomic.StoreInt32(&lConn.stale, 1)
+}
+
+// reorder repairs the heap if the in-flight counts changed since the
+// last pick.
+func (lConn *LeastConnections) reorder() {
+	if atomic.LoadInt32(&lConn.stale) == 0 {
+		return
+	}
+	lConn.mu.Lock()
+	defer lConn.mu.Unlock()
+	// Counts changing from here on mark the heap stale again.
+	if atomic.SwapInt32(&lConn.stale, 0) == 1 {
+		lConn.backends.Heapify()
 	}
+}
 
-	var backends []*Backend
-	for _, rawURL := range rawURLs {
-		if backend, err := NewBackend(rawURL); err != nil {
-			return nil, err
-		} else {
-			backends = append(backends, backend)
-		}
+func NewLeastConnections(beCfg *pb.BackendConfig) (*LeastConnections, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	lConn, err := newLeastConnsWithbackends(backends)
+	if err != nil {
+		return nil, err
 	}
-	return newLeastConnsWithbackends(backends)
+	lConn.beCfg = beCfg
+	return lConn, nil
 }
 
 func (lConn *LeastConnections) Register(rawURL string) error {
-	newBe, err := NewBackend(rawURL)
+	newBe, err := NewBackendWithConfig(rawURL, lConn.beCfg)
 	if err != nil {
 		return err
 	}
+	newBe.inFlightChanged = lConn.markStale
 	lConn.mu.Lock()
 	defer lConn.mu.Unlock()
-	if !lConn.backends.Push(rawURL, newBe) {
+	if !lConn.backends.Push(newBe.URL(), newBe) {
 		log.Printf("%v already registered", rawURL)
 	}
 	return nil
@@ -77,9 +99,12 @@ func (lConn *LeastConnections) Register(rawURL string) error {
 func (lConn *LeastConnections) Deregister(rawURL string) error {
 	lConn.mu.Lock()
 	defer lConn.mu.Unlock()
-	if !lConn.backends.Remove(rawURL) {
+	be := lConn.backends.Get(rawURL)
+	if be == nil {
 		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
 	}
+	lConn.backends.Remove(rawURL)
+	be.closeIdleConnections()
 	return nil
 }
 
@@ -97,7 +122,7 @@ func (lConn *LeastConnections) bestAliveBE(r *http.Request) *Backend {
 	for !lConn.backends.Empty() {
 		minConnsBE := lConn.backends.Pop()
 		popped = append(popped, minConnsBE)
-		if minConnsBE.IsAliveAndReady() {
+		if minConnsBE.availableFor(r) {
 			return minConnsBE
 		}
 	}
@@ -109,9 +134,10 @@ func (lConn *LeastConnections) nextBackend(r *http.Request) *Backend {
 		return nil
 	}
 
+	lConn.reorder()
 	lConn.mu.RLock()
 	// Try top optimistically
-	if !lConn.backends.Empty() && lConn.backends.Top().IsAliveAndReady() {
+	if !lConn.backends.Empty() && lConn.backends.Top().availableFor(r) {
 		minConnsBE := lConn.backends.Top()
 		lConn.mu.RUnlock()
 		return minConnsBE
@@ -121,15 +147,15 @@ func (lConn *LeastConnections) nextBackend(r *http.Request) *Backend {
 }
 
 func (lConn *LeastConnections) Handler(r *http.Request) http.Handler {
-	minConnsBE := lConn.nextBackend(r)
-	if minConnsBE == nil {
-		return UnavailableHandler{}
-	}
-	lConn.mu.Lock()
-	defer lConn.mu.Unlock()
-	res, _ := minConnsBE.GetOpenConnection(r)
-	lConn.backends.Emplace(minConnsBE.URL(), minConnsBE)
-	return res
+	// The heap is reordered by the next pick once the request starts.
+	return openConnection(r, lConn.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (lConn *LeastConnections) Lookup(rawURL string) *Backend {
+	lConn.mu.RLock()
+	defer lConn.mu.RUnlock()
+	return lConn.backends.Get(rawURL)
 }
 
 func (lConn *LeastConnections) RegisterCheck(ctx context.Context, chk *Checker) {
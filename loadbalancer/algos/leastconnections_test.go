@@ -1,16 +1,15 @@
 package algos
 
 import (
-	"net/http"
 	"testing"
 )
 
 func TestLeastConnsHandler(t *testing.T) {
 	readyBE := upAndReadyBackend(t, 0)
-	readyBEWith3Cons := readyBackendWithConnections(t, []http.Handler{nil, nil, nil})
+	readyBEWith3Cons := readyBackendWithConnections(t, 3)
 	unreadyBe1 := unreadyBackend(t, 1)
 	unreadyBe2 := unreadyBackend(t, 2)
-	unreadyBeWith3cons := unreadyBackendWithConnections(t, []http.Handler{nil, nil, nil})
+	unreadyBeWith3cons := unreadyBackendWithConnections(t, 3)
 
 	tests := []struct {
 		name     string
@@ -55,3 +54,31 @@ func TestLeastConnsHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestLeastConnsReordersOnInFlightChange(t *testing.T) {
+	first := aliveBackendAt(t, "http://first:8080")
+	second := aliveBackendAt(t, "http://second:8080")
+	leastConns, _ := newLeastConnsWithbackends([]*Backend{first, second})
+
+	top := leastConns.nextBackend(nil)
+	other := first
+	if top == first {
+		other = second
+	}
+
+	top.addInFlight(1)
+	if got := leastConns.nextBackend(nil); got != other {
+		t.Errorf("after a request started want %v, got %v", other, got)
+	}
+
+	other.addInFlight(2)
+	if got := leastConns.nextBackend(nil); got != top {
+		t.Errorf("after two requests started want %v, got %v", top, got)
+	}
+
+	other.addInFlight(-2)
+	top.addInFlight(-1)
+	if got := other.ConnectionsCount() + top.ConnectionsCount(); got != 0 {
+		t.Errorf("want no requests in flight after they finished, got %v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package algos
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// LoadHeader is the response header through which backends can report
+// their load, as a comma separated list of key=value pairs, for example
+// "cpu=0.42, mem=0.61, queue=3". The same format is accepted as the body
+// of health check responses.
+const LoadHeader = "X-Backend-Load"
+
+// minCapacity keeps fully loaded backends selectable with a small probability,
+// otherwise they would never get the chance to report they recovered.
+const minCapacity = 0.01
+
+// unknownLoad is assumed for backends that did not report their load yet.
+var unknownLoad = LoadReport{CPU: 0.5, Memory: 0.5}
+
+// LoadReport is the resource usage reported by a backend.
+type LoadReport struct {
+	// Fraction of the CPU in use, between 0 and 1.
+	CPU float64
+	// Fraction of the memory in use, between 0 and 1.
+	Memory float64
+	// Number of requests waiting to be processed.
+	QueueDepth int
+}
+
+func (lr LoadReport) String() string {
+	return fmt.Sprintf("cpu=%.2f, mem=%.2f, queue=%d", lr.CPU, lr.Memory, lr.QueueDepth)
+}
+
+// ParseLoadReport parses a report in the LoadHeader format.
+// Unknown keys are ignored, but at least one known key must be present.
+func ParseLoadReport(raw string) (LoadReport, error) {
+	var report LoadReport
+	found := false
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return LoadReport{}, fmt.Errorf("malformed load entry %q", pair)
+		}
+		var err error
+		switch strings.TrimSpace(key) {
+		case "cpu":
+			report.CPU, err = parseFraction(value)
+		case "mem":
+			report.Memory, err = parseFraction(value)
+		case "queue":
+			report.QueueDepth, err = strconv.Atoi(strings.TrimSpace(value))
+			if err == nil && report.QueueDepth < 0 {
+				err = fmt.Errorf("negative queue depth %v", report.QueueDepth)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return LoadReport{}, fmt.Errorf("invalid %v load: %v", key, err)
+		}
+		found = true
+	}
+	if !found {
+		return LoadReport{}, fmt.Errorf("no load found in %q", raw)
+	}
+	return report, nil
+}
+
+func parseFraction(raw string) (float64, error) {
+	val, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0, err
+	}
+	if val < 0 || val > 1 {
+		return 0, fmt.Errorf("%v is not between 0 and 1", val)
+	}
+	return val, nil
+}
+
+// freeCapacity scores how much more work the backend can take,
+// higher is better.
+func (lr LoadReport) freeCapacity() float64 {
+	capacity := (1 - lr.CPU) * (1 - lr.Memory) / float64(1+lr.QueueDepth)
+	if capacity < minCapacity {
+		return minCapacity
+	}
+	return capacity
+}
+
+// readLoadHeader records the load reported in a proxied response, and
+// hides the header from the client.
+func (b *Backend) readLoadHeader(resp *http.Response) error {
+	rawLoad := resp.Header.Get(LoadHeader)
+	if len(rawLoad) == 0 {
+		return nil
+	}
+	resp.Header.Del(LoadHeader)
+	if report, err := ParseLoadReport(rawLoad); err == nil {
+		b.ReportLoad(report)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package algos
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseLoadReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    LoadReport
+		wantErr bool
+	}{
+		{
+			name: "Full report",
+			raw:  "cpu=0.42, mem=0.61, queue=3",
+			want: LoadReport{CPU: 0.42, Memory: 0.61, QueueDepth: 3},
+		},
+		{
+			name: "Partial report, unknown keys are ignored",
+			raw:  "cpu=0.5,disk=0.9",
+			want: LoadReport{CPU: 0.5},
+		},
+		{
+			name:    "Plain health body is not a report",
+			raw:     "OK",
+			wantErr: true,
+		},
+		{
+			name:    "Only unknown keys is not a report",
+			raw:     "disk=0.9",
+			wantErr: true,
+		},
+		{
+			name:    "CPU over 1 is rejected",
+			raw:     "cpu=1.5",
+			wantErr: true,
+		},
+		{
+			name:    "Negative queue is rejected",
+			raw:     "queue=-1",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseLoadReport(test.raw)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseLoadReport(%q) want error, got %v", test.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ParseLoadReport(%q) unexpected error %v", test.raw, err)
+			} else if got != test.want {
+				t.Errorf("ParseLoadReport(%q) want %v, got %v", test.raw, test.want, got)
+			}
+		})
+	}
+}
+
+func TestReadLoadHeader(t *testing.T) {
+	be := &Backend{}
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set(LoadHeader, "cpu=0.25, mem=0.5, queue=2")
+
+	be.readLoadHeader(resp)
+	got, ok := be.Load()
+	want := LoadReport{CPU: 0.25, Memory: 0.5, QueueDepth: 2}
+	if !ok || got != want {
+		t.Errorf("be.Load() want %v, got %v (reported %v)", want, got, ok)
+	}
+	if resp.Header.Get(LoadHeader) != "" {
+		t.Errorf("want %v header removed from the response, got %q", LoadHeader, resp.Header.Get(LoadHeader))
+	}
+}
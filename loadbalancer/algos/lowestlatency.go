@@ -0,0 +1,106 @@
+package algos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// LowestLatency sends requests to the alive and ready backend with the
+// lowest latency score, its moving average of response times scaled by its
+// requests in flight, so concurrent requests do not all herd onto the
+// fastest backend. Backends without latency yet, like the newly registered
+// ones, are compared by their requests in flight only.
+type LowestLatency struct {
+	// start rotates the first backend looked at, spreading ties evenly.
+	start    uint64
+	backends *backendSet
+	beCfg    *pb.BackendConfig
+	mu       sync.RWMutex
+}
+
+func newLowestLatencyWithBackends(backends []*Backend) *LowestLatency {
+	return &LowestLatency{
+		backends: newBackendSet(backends),
+	}
+}
+
+func NewLowestLatency(beCfg *pb.BackendConfig) (*LowestLatency, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	ll := newLowestLatencyWithBackends(backends)
+	ll.beCfg = beCfg
+	return ll, nil
+}
+
+func (ll *LowestLatency) Register(rawURL string) error {
+	newBe, err := NewBackendWithConfig(rawURL, ll.beCfg)
+	if err != nil {
+		return err
+	}
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	if !ll.backends.add(newBe) {
+		log.Printf("%v already registered", rawURL)
+	}
+	return nil
+}
+
+func (ll *LowestLatency) Deregister(rawURL string) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	if !ll.backends.remove(rawURL) {
+		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
+	}
+	return nil
+}
+
+func (ll *LowestLatency) nextBackend(r *http.Request) *Backend {
+	ll.mu.RLock()
+	defer ll.mu.RUnlock()
+	count := ll.backends.size()
+	if count == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint64(&ll.start, 1) % uint64(count))
+	var fastest *Backend
+	for i := 0; i < count; i++ {
+		be := ll.backends.backends[(start+i)%count]
+		if !be.availableFor(r) {
+			continue
+		}
+		if fastest == nil || lessLoaded(be, fastest) {
+			fastest = be
+		}
+	}
+	return fastest
+}
+
+func (ll *LowestLatency) Handler(r *http.Request) http.Handler {
+	return openConnection(r, ll.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (ll *LowestLatency) Lookup(rawURL string) *Backend {
+	ll.mu.RLock()
+	defer ll.mu.RUnlock()
+	return ll.backends.get(rawURL)
+}
+
+func (ll *LowestLatency) RegisterCheck(ctx context.Context, chk *Checker) {
+	chk.beSupplier = func() []*Backend {
+		ll.mu.RLock()
+		defer ll.mu.RUnlock()
+		return ll.backends.values()
+	}
+
+	chk.runInBackground(ctx)
+}
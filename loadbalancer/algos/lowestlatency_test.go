@@ -0,0 +1,119 @@
+package algos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLowestLatencyHandler(t *testing.T) {
+	fastBE := readyBackendWithLatency(t, 0, 10*time.Millisecond)
+	slowBE := readyBackendWithLatency(t, 1, 200*time.Millisecond)
+	newBE := upAndReadyBackend(t, 2)
+	busyFastBE := readyBackendWithLatency(t, 4, 10*time.Millisecond)
+	busyFastBE.inFlight = 30
+	unreadyFastBE := unreadyBackend(t, 3)
+	unreadyFastBE.observeLatency(time.Millisecond, time.Now())
+
+	tests := []struct {
+		name     string
+		backends []*Backend
+		wantBE   *Backend
+	}{
+		{
+			name:     "Fastest BE is chosen",
+			backends: []*Backend{slowBE, fastBE},
+			wantBE:   fastBE,
+		},
+		{
+			name:     "Busy fast BE loses to an idle slower one",
+			backends: []*Backend{busyFastBE, slowBE},
+			wantBE:   slowBE,
+		},
+		{
+			name:     "BE without measurements is compared by requests in flight",
+			backends: []*Backend{busyFastBE, newBE},
+			wantBE:   newBE,
+		},
+		{
+			name:     "Unready BEs are skipped, choses the fastest ready one",
+			backends: []*Backend{unreadyFastBE, slowBE, fastBE},
+			wantBE:   fastBE,
+		},
+		{
+			name:     "Unready BEs are skipped, returns nil",
+			backends: []*Backend{unreadyFastBE},
+			wantBE:   nil,
+		},
+		{
+			name:     "No BEs, returns nil",
+			backends: []*Backend{},
+			wantBE:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ll := newLowestLatencyWithBackends(test.backends)
+			for i := 0; i < len(test.backends)+1; i++ {
+				if be := ll.nextBackend(nil); be != test.wantBE {
+					t.Errorf("want %v, got %v", test.wantBE, be)
+				}
+			}
+		})
+	}
+}
+
+func TestLowestLatencyDeregister(t *testing.T) {
+	fastBE := readyBackendWithLatency(t, 0, 10*time.Millisecond)
+	slowBE := readyBackendWithLatency(t, 1, 200*time.Millisecond)
+	ll := newLowestLatencyWithBackends([]*Backend{fastBE, slowBE})
+
+	if err := ll.Deregister(fastBE.URL()); err != nil {
+		t.Fatalf("unexpected error deregistering: %v", err)
+	}
+	if be := ll.nextBackend(nil); be != slowBE {
+		t.Errorf("want %v, got %v", slowBE, be)
+	}
+	if err := ll.Deregister(fastBE.URL()); err == nil {
+		t.Errorf("want error deregistering unknown backend, got nil")
+	}
+}
+
+func TestLowestLatencyAvoidsFastFailures(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer healthy.Close()
+	failingBE, healthyBE := aliveBackendAt(t, failing.URL), aliveBackendAt(t, healthy.URL)
+	ll := newLowestLatencyWithBackends([]*Backend{failingBE, healthyBE})
+
+	hits := make(map[*Backend]int)
+	for i := 0; i < 20; i++ {
+		handler := ll.Handler(httptest.NewRequest("GET", "/", nil))
+		hits[BackendOf(handler)]++
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if hits[failingBE] > 1 {
+		t.Errorf("want the failing backend tried once, got %v requests", hits[failingBE])
+	}
+}
+
+func TestLowestLatencyRetriesRecoveredBackend(t *testing.T) {
+	fastBE := upAndReadyBackend(t, 0)
+	slowBE := upAndReadyBackend(t, 1)
+	now := time.Now()
+	fastBE.observeLatency(10*time.Millisecond, now)
+	slowBE.observeLatency(200*time.Millisecond, now.Add(-time.Minute))
+	ll := newLowestLatencyWithBackends([]*Backend{fastBE, slowBE})
+
+	// The slow backend was not picked for a minute, its average decayed.
+	if be := ll.nextBackend(nil); be != slowBE {
+		t.Errorf("want %v, got %v", slowBE, be)
+	}
+}
@@ -0,0 +1,185 @@
+package algos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const defaultMaglevTableSize = 65537
+
+// maxMaglevFallbacks bounds the table entries looked at after the one of
+// the key when its backend cannot be picked. Past them, the backends are
+// looked at directly, which is cheaper than walking a table mostly made
+// of backends that are down.
+const maxMaglevFallbacks = 32
+
+// Maglev hashes the request key into a lookup table where every backend
+// owns an almost equal number of entries, as described in "Maglev: A Fast
+// and Reliable Software Network Load Balancer" (Eisenbud et al.).
+// Picking a backend is O(1) and, like consistent hashing, changing the
+// backends only moves a small share of the keys. The table is rebuilt
+// on every Register and Deregister.
+type Maglev struct {
+	backends  *backendSet
+	table     []*Backend
+	tableSize uint64
+	key       keyFunc
+	beCfg     *pb.BackendConfig
+	mu        sync.RWMutex
+}
+
+func newMaglevWithBackends(backends []*Backend, beCfg *pb.BackendConfig) (*Maglev, error) {
+	key, err := newKeyFunc(beCfg.GetHashPolicy())
+	if err != nil {
+		return nil, err
+	}
+	tableSize := int64(beCfg.GetMaglev().GetTableSize())
+	if tableSize == 0 {
+		tableSize = defaultMaglevTableSize
+	}
+	if tableSize < 2 || !big.NewInt(tableSize).ProbablyPrime(0) {
+		return nil, fmt.Errorf("Maglev table size must be a prime, got %v", tableSize)
+	}
+
+	m := &Maglev{
+		backends:  newBackendSet(backends),
+		tableSize: uint64(tableSize),
+		key:       key,
+		beCfg:     beCfg,
+	}
+	m.populate()
+	return m, nil
+}
+
+func NewMaglev(beCfg *pb.BackendConfig) (*Maglev, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newMaglevWithBackends(backends, beCfg)
+}
+
+// populate fills the lookup table, callers must hold the write lock.
+// Every backend has its own permutation of the table positions, the
+// backends take turns claiming their next free position until the table
+// is full, so each of them ends up with M/N entries, give or take one.
+func (m *Maglev) populate() {
+	backends := m.backends.values()
+	if len(backends) == 0 {
+		m.table = nil
+		return
+	}
+	// Sort so every load balancer instance builds the same table.
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].URL() < backends[j].URL()
+	})
+
+	offsets := make([]uint64, len(backends))
+	skips := make([]uint64, len(backends))
+	next := make([]uint64, len(backends))
+	for i, be := range backends {
+		offsets[i] = hashString("offset:"+be.URL()) % m.tableSize
+		skips[i] = hashString("skip:"+be.URL())%(m.tableSize-1) + 1
+	}
+
+	table := make([]*Backend, m.tableSize)
+	for filled := uint64(0); ; {
+		for i, be := range backends {
+			pos := (offsets[i] + next[i]*skips[i]) % m.tableSize
+			for table[pos] != nil {
+				next[i]++
+				pos = (offsets[i] + next[i]*skips[i]) % m.tableSize
+			}
+			table[pos] = be
+			next[i]++
+			filled++
+			if filled == m.tableSize {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+func (m *Maglev) Register(rawURL string) error {
+	newBe, err := NewBackendWithConfig(rawURL, m.beCfg)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.backends.add(newBe) {
+		log.Printf("%v already registered", rawURL)
+		return nil
+	}
+	m.populate()
+	return nil
+}
+
+func (m *Maglev) Deregister(rawURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.backends.remove(rawURL) {
+		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
+	}
+	m.populate()
+	return nil
+}
+
+// backendFor looks up the table entry of hash, falling back to a few of
+// the following entries, then to the other backends, while the backend is
+// not alive and ready, or r excludes it.
+func (m *Maglev) backendFor(r *http.Request, hash uint64) *Backend {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.table) == 0 {
+		return nil
+	}
+
+	start := hash % m.tableSize
+	for i := uint64(0); i <= maxMaglevFallbacks && i < m.tableSize; i++ {
+		if be := m.table[(start+i)%m.tableSize]; be.availableFor(r) {
+			return be
+		}
+	}
+	backends := m.backends.backends
+	first := int(hash % uint64(len(backends)))
+	for i := range backends {
+		if be := backends[(first+i)%len(backends)]; be.availableFor(r) {
+			return be
+		}
+	}
+	return nil
+}
+
+func (m *Maglev) nextBackend(r *http.Request) *Backend {
+	return m.backendFor(r, hashString(m.key(r)))
+}
+
+func (m *Maglev) Handler(r *http.Request) http.Handler {
+	return openConnection(r, m.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (m *Maglev) Lookup(rawURL string) *Backend {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.backends.get(rawURL)
+}
+
+func (m *Maglev) RegisterCheck(ctx context.Context, chk *Checker) {
+	chk.beSupplier = func() []*Backend {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.backends.values()
+	}
+
+	chk.runInBackground(ctx)
+}
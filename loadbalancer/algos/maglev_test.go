@@ -0,0 +1,150 @@
+package algos
+
+import (
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func maglevConfig(tableSize int32) *pb.BackendConfig {
+	return &pb.BackendConfig{
+		Maglev: &pb.MaglevConfig{
+			TableSize: proto.Int32(tableSize),
+		},
+	}
+}
+
+func TestMaglevRejectsNonPrimeTable(t *testing.T) {
+	if _, err := newMaglevWithBackends(nil, maglevConfig(1000)); err == nil {
+		t.Errorf("want error for a non prime table size, got nil")
+	}
+}
+
+func TestMaglevTableIsBalanced(t *testing.T) {
+	backends := aliveBackends(t, 7)
+	m, err := newMaglevWithBackends(backends, maglevConfig(5003))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	counts := make(map[*Backend]int)
+	for _, be := range m.table {
+		counts[be]++
+	}
+	// Backends take turns filling the table, so they differ by one entry at most.
+	for _, be := range backends {
+		if got := counts[be]; got != 5003/7 && got != 5003/7+1 {
+			t.Errorf("%v owns %v entries, want %v or %v", be, got, 5003/7, 5003/7+1)
+		}
+	}
+}
+
+// disruption returns the share of table entries that changed backend.
+func disruption(before, after []*Backend, ignore *Backend) float64 {
+	changed := 0
+	for i := range before {
+		if before[i] != ignore && after[i] != ignore && before[i] != after[i] {
+			changed++
+		}
+	}
+	return float64(changed) / float64(len(before))
+}
+
+func TestMaglevChurnDisruption(t *testing.T) {
+	const tableSize = 65537
+	const backendCount = 20
+
+	t.Run("Deregister", func(t *testing.T) {
+		backends := aliveBackends(t, backendCount)
+		m, err := newMaglevWithBackends(backends, maglevConfig(tableSize))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		before := m.table
+		removed := backends[5]
+		if err := m.Deregister(removed.URL()); err != nil {
+			t.Fatalf("unexpected error deregistering: %v", err)
+		}
+		for _, be := range m.table {
+			if be == removed {
+				t.Fatalf("removed backend %v still in the table", removed)
+			}
+		}
+		// Besides the removed backend's own entries, the paper measures only a
+		// few percent of the table moving when a backend is removed.
+		if got := disruption(before, m.table, removed); got > 0.02 {
+			t.Errorf("%.2f%% of the other entries moved, want at most 2%%", got*100)
+		}
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		backends := aliveBackends(t, backendCount)
+		m, err := newMaglevWithBackends(backends, maglevConfig(tableSize))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		before := m.table
+		newURL := "http://backend-new:8080"
+		if err := m.Register(newURL); err != nil {
+			t.Fatalf("unexpected error registering: %v", err)
+		}
+		added := m.backends.get(newURL)
+		owned := 0
+		for _, be := range m.table {
+			if be == added {
+				owned++
+			}
+		}
+		if owned < tableSize/(backendCount+1) {
+			t.Errorf("new backend owns %v entries, want at least %v", owned, tableSize/(backendCount+1))
+		}
+		if got := disruption(before, m.table, added); got > 0.02 {
+			t.Errorf("%.2f%% of the other entries moved, want at most 2%%", got*100)
+		}
+	})
+}
+
+func TestMaglevSkipsUnready(t *testing.T) {
+	backends := aliveBackends(t, 3)
+	m, err := newMaglevWithBackends(backends, maglevConfig(251))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	dead := backends[1]
+	dead.SetAlive(false)
+	for hash := uint64(0); hash < 251; hash++ {
+		got := m.backendFor(nil, hash)
+		if got == dead {
+			t.Fatalf("hash %v went to the dead backend", hash)
+		}
+		if owner := m.table[hash]; owner != dead && got != owner {
+			t.Errorf("hash %v went to %v, want its owner %v", hash, got, owner)
+		}
+	}
+
+	for _, be := range backends {
+		be.SetAlive(false)
+	}
+	if be := m.backendFor(nil, 7); be != nil {
+		t.Errorf("want nil with no alive backends, got %v", be)
+	}
+}
+
+func TestMaglevFallsBackWhenMostAreDown(t *testing.T) {
+	backends := aliveBackends(t, 100)
+	m, err := newMaglevWithBackends(backends, maglevConfig(65537))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, be := range backends[1:] {
+		be.SetAlive(false)
+	}
+
+	for hash := uint64(0); hash < 1000; hash++ {
+		if got := m.backendFor(nil, hash); got != backends[0] {
+			t.Fatalf("hash %v went to %v, want the only alive backend", hash, got)
+		}
+	}
+}
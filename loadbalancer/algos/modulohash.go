@@ -0,0 +1,121 @@
+package algos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// ModuloHash sends a request to the backend at its key hash modulo the
+// number of alive and ready backends, in registration order. The same key
+// keeps going to the same backend while the healthy set does not change,
+// which works for clients that cannot hold cookies. When a backend goes
+// down, keys are rehashed over the remaining ones.
+type ModuloHash struct {
+	backends *backendSet
+	key      keyFunc
+	beCfg    *pb.BackendConfig
+	mu       sync.RWMutex
+}
+
+func newModuloHashWithBackends(backends []*Backend, beCfg *pb.BackendConfig) (*ModuloHash, error) {
+	key, err := newKeyFunc(beCfg.GetHashPolicy())
+	if err != nil {
+		return nil, err
+	}
+	return &ModuloHash{
+		backends: newBackendSet(backends),
+		key:      key,
+		beCfg:    beCfg,
+	}, nil
+}
+
+func NewModuloHash(beCfg *pb.BackendConfig) (*ModuloHash, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newModuloHashWithBackends(backends, beCfg)
+}
+
+func (mh *ModuloHash) Register(rawURL string) error {
+	newBe, err := NewBackendWithConfig(rawURL, mh.beCfg)
+	if err != nil {
+		return err
+	}
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	if !mh.backends.add(newBe) {
+		log.Printf("%v already registered", rawURL)
+	}
+	return nil
+}
+
+func (mh *ModuloHash) Deregister(rawURL string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	if !mh.backends.remove(rawURL) {
+		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
+	}
+	return nil
+}
+
+// backendFor returns the alive and ready backend at hash modulo their
+// count, leaving out the ones r excludes.
+func (mh *ModuloHash) backendFor(r *http.Request, hash uint64) *Backend {
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+	healthy := 0
+	for _, be := range mh.backends.backends {
+		if be.availableFor(r) {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return nil
+	}
+
+	target := int(hash % uint64(healthy))
+	var last *Backend
+	for _, be := range mh.backends.backends {
+		if !be.availableFor(r) {
+			continue
+		}
+		last = be
+		if target == 0 {
+			return be
+		}
+		target--
+	}
+	// Some backend went down since counting them.
+	return last
+}
+
+func (mh *ModuloHash) nextBackend(r *http.Request) *Backend {
+	return mh.backendFor(r, hashString(mh.key(r)))
+}
+
+func (mh *ModuloHash) Handler(r *http.Request) http.Handler {
+	return openConnection(r, mh.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (mh *ModuloHash) Lookup(rawURL string) *Backend {
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+	return mh.backends.get(rawURL)
+}
+
+func (mh *ModuloHash) RegisterCheck(ctx context.Context, chk *Checker) {
+	chk.beSupplier = func() []*Backend {
+		mh.mu.RLock()
+		defer mh.mu.RUnlock()
+		return mh.backends.values()
+	}
+
+	chk.runInBackground(ctx)
+}
@@ -0,0 +1,80 @@
+package algos
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestModuloHashIsDeterministic(t *testing.T) {
+	backends := aliveBackends(t, 4)
+	mh, err := newModuloHashWithBackends(backends, &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	counts := make(map[*Backend]int)
+	for i := 0; i < testKeys; i++ {
+		hash := hashString(fmt.Sprintf("user-%d", i))
+		be := mh.backendFor(nil, hash)
+		if again := mh.backendFor(nil, hash); again != be {
+			t.Fatalf("key %v went to %v, then to %v", i, be, again)
+		}
+		counts[be]++
+	}
+	for _, be := range backends {
+		if share := counts[be]; share < testKeys/4*9/10 || share > testKeys/4*11/10 {
+			t.Errorf("%v got %v keys, want about %v", be, share, testKeys/4)
+		}
+	}
+}
+
+func TestModuloHashRehashesWhenBackendIsDown(t *testing.T) {
+	backends := aliveBackends(t, 3)
+	mh, err := newModuloHashWithBackends(backends, &pb.BackendConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	backends[1].SetAlive(false)
+	for i := 0; i < 100; i++ {
+		hash := hashString(fmt.Sprintf("user-%d", i))
+		want := []*Backend{backends[0], backends[2]}[hash%2]
+		if got := mh.backendFor(nil, hash); got != want {
+			t.Errorf("key %v want %v, got %v", i, want, got)
+		}
+	}
+
+	backends[0].SetAlive(false)
+	backends[2].SetAlive(false)
+	if got := mh.backendFor(nil, hashString("user-0")); got != nil {
+		t.Errorf("with no alive backends want nil, got %v", got)
+	}
+}
+
+func TestModuloHashByHeader(t *testing.T) {
+	beCfg := &pb.BackendConfig{
+		HashPolicy: &pb.HashPolicy{
+			Source: pb.HashPolicy_HEADER.Enum(),
+			Name:   proto.String("X-Tenant"),
+		},
+	}
+	mh, err := newModuloHashWithBackends(aliveBackends(t, 5), beCfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	first := httptest.NewRequest("GET", "/a", nil)
+	first.RemoteAddr = "10.0.0.1:1000"
+	first.Header.Set("X-Tenant", "acme")
+	second := httptest.NewRequest("POST", "/b", nil)
+	second.RemoteAddr = "10.0.0.2:2000"
+	second.Header.Set("X-Tenant", "acme")
+
+	if a, b := mh.nextBackend(first), mh.nextBackend(second); a != b {
+		t.Errorf("requests of the same tenant want the same backend, got %v and %v", a, b)
+	}
+}
@@ -0,0 +1,142 @@
+package algos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// p2cAttempts is how many random pairs are drawn before falling back
+// to scanning all the backends for an alive one.
+const p2cAttempts = 3
+
+// P2C implements the power of two choices: it draws two random alive and
+// ready backends and sends the request to the less loaded one. This gets
+// close to LeastConnections balance without keeping a global ordering,
+// so picking only needs a read lock.
+type P2C struct {
+	backends *backendSet
+	beCfg    *pb.BackendConfig
+	// intn returns a random number in [0, n), replaceable for tests.
+	intn func(n int) int
+	mu   sync.RWMutex
+}
+
+func newP2CWithBackends(backends []*Backend) *P2C {
+	return &P2C{
+		backends: newBackendSet(backends),
+		intn:     rand.Intn,
+	}
+}
+
+func NewP2C(beCfg *pb.BackendConfig) (*P2C, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	p := newP2CWithBackends(backends)
+	p.beCfg = beCfg
+	return p, nil
+}
+
+func (p *P2C) Register(rawURL string) error {
+	newBe, err := NewBackendWithConfig(rawURL, p.beCfg)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.backends.add(newBe) {
+		log.Printf("%v already registered", rawURL)
+	}
+	return nil
+}
+
+func (p *P2C) Deregister(rawURL string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.backends.remove(rawURL) {
+		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
+	}
+	return nil
+}
+
+// lessLoaded compares the latency scores, the moving average latency scaled
+// by the requests in flight, when both backends have latency data.
+// Otherwise it compares only the requests in flight.
+func lessLoaded(a, b *Backend) bool {
+	aConns, bConns := a.ConnectionsCount(), b.ConnectionsCount()
+	aLatency, bLatency := a.Latency(), b.Latency()
+	if aLatency > 0 && bLatency > 0 {
+		return int64(aLatency)*int64(aConns+1) < int64(bLatency)*int64(bConns+1)
+	}
+	return aConns < bConns
+}
+
+func (p *P2C) nextBackend(r *http.Request) *Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	count := p.backends.size()
+	if count == 0 {
+		return nil
+	} else if count == 1 {
+		if be := p.backends.backends[0]; be.availableFor(r) {
+			return be
+		}
+		return nil
+	}
+
+	for i := 0; i < p2cAttempts; i++ {
+		first := p.intn(count)
+		second := p.intn(count - 1)
+		if second >= first {
+			second++ // make sure the two choices differ
+		}
+		a, b := p.backends.backends[first], p.backends.backends[second]
+		aReady, bReady := a.availableFor(r), b.availableFor(r)
+		if aReady && bReady {
+			if lessLoaded(b, a) {
+				return b
+			}
+			return a
+		} else if aReady {
+			return a
+		} else if bReady {
+			return b
+		}
+	}
+
+	// Most backends are down, look for any alive one.
+	for _, be := range p.backends.backends {
+		if be.availableFor(r) {
+			return be
+		}
+	}
+	return nil
+}
+
+func (p *P2C) Handler(r *http.Request) http.Handler {
+	return openConnection(r, p.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (p *P2C) Lookup(rawURL string) *Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.backends.get(rawURL)
+}
+
+func (p *P2C) RegisterCheck(ctx context.Context, chk *Checker) {
+	chk.beSupplier = func() []*Backend {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.backends.values()
+	}
+
+	chk.runInBackground(ctx)
+}
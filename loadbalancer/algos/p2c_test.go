@@ -0,0 +1,110 @@
+package algos
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedDraws returns the given numbers in order, then repeats the last one.
+func fixedDraws(draws ...int) func(int) int {
+	return func(n int) int {
+		res := draws[0]
+		if len(draws) > 1 {
+			draws = draws[1:]
+		}
+		return res % n
+	}
+}
+
+func TestP2CHandler(t *testing.T) {
+	idle := withConnections(aliveBackendAt(t, "http://idle:8080"), 1)
+	busy := withConnections(aliveBackendAt(t, "http://busy:8080"), 5)
+	other := withConnections(aliveBackendAt(t, "http://other:8080"), 3)
+	dead := aliveBackendAt(t, "http://dead:8080")
+	dead.SetAlive(false)
+	fastBusy := withConnections(aliveBackendAt(t, "http://fast-busy:8080"), 3)
+	fastBusy.observeLatency(10*time.Millisecond, time.Now())
+	slowIdle := withConnections(aliveBackendAt(t, "http://slow-idle:8080"), 1)
+	slowIdle.observeLatency(100*time.Millisecond, time.Now())
+
+	tests := []struct {
+		name     string
+		backends []*Backend
+		draws    []int
+		wantBE   *Backend
+	}{
+		{
+			name:     "Fewer requests in flight wins",
+			backends: []*Backend{busy, other, idle},
+			draws:    []int{0, 1}, // busy and idle
+			wantBE:   idle,
+		},
+		{
+			name:     "Only the drawn pair is compared",
+			backends: []*Backend{busy, other, idle},
+			draws:    []int{0, 0}, // busy and other
+			wantBE:   other,
+		},
+		{
+			name:     "Latency score wins when known",
+			backends: []*Backend{fastBusy, slowIdle},
+			draws:    []int{0, 0},
+			wantBE:   fastBusy, // 10ms * 4 < 100ms * 2
+		},
+		{
+			name:     "Dead choice is skipped",
+			backends: []*Backend{dead, busy},
+			draws:    []int{0, 0},
+			wantBE:   busy,
+		},
+		{
+			name:     "Falls back to any alive backend",
+			backends: []*Backend{dead, dead, busy},
+			draws:    []int{0, 0}, // always the two dead ones
+			wantBE:   busy,
+		},
+		{
+			name:     "Single backend",
+			backends: []*Backend{idle},
+			wantBE:   idle,
+		},
+		{
+			name:     "No alive backends, returns nil",
+			backends: []*Backend{dead},
+			wantBE:   nil,
+		},
+		{
+			name:     "No BEs, returns nil",
+			backends: []*Backend{},
+			wantBE:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &P2C{
+				backends: &backendSet{backends: test.backends},
+				intn:     fixedDraws(append(test.draws, 0)...),
+			}
+			if be := p.nextBackend(nil); be != test.wantBE {
+				t.Errorf("want %v, got %v", test.wantBE, be)
+			}
+		})
+	}
+}
+
+func TestP2CBalancesLoad(t *testing.T) {
+	backends := aliveBackends(t, 10)
+	p := newP2CWithBackends(backends)
+
+	// Simulate requests that never finish, the spread should stay tight.
+	for i := 0; i < 1000; i++ {
+		be := p.nextBackend(nil)
+		be.addInFlight(1)
+	}
+	for _, be := range backends {
+		if conns := be.ConnectionsCount(); conns < 80 || conns > 120 {
+			t.Errorf("%v got %v requests, want about 100", be, conns)
+		}
+	}
+}
@@ -115,6 +115,13 @@ func (addrPQ *AdressablePQ[K, V]) repairIndex(idx int) {
 	}
 }
 
+// Heapify restores the order of the queue after the values changed in place.
+func (addrPQ *AdressablePQ[K, V]) Heapify() {
+	for idx := len(addrPQ.entries)/2 - 1; idx >= 0; idx-- {
+		addrPQ.heapifyDown(idx)
+	}
+}
+
 func (addrPQ *AdressablePQ[K, V]) Remove(key K) bool {
 	if idx, ok := addrPQ.keyMap[key]; !ok {
 		return false
@@ -376,3 +376,40 @@ func TestTop(t *testing.T) {
 		})
 	}
 }
+
+func TestHeapify(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []int
+		want     []int
+	}{
+		{
+			name:     "Already ordered",
+			existing: []int{5, 3, 2},
+			want:     []int{5, 3, 2},
+		},
+		{
+			name:     "Reversed",
+			existing: []int{1, 2, 3},
+			want:     []int{3, 2, 1},
+		},
+		{
+			name:     "Small value sifts down the levels",
+			existing: []int{2, 5, 3, 4, 1},
+			want:     []int{5, 4, 3, 2, 1},
+		},
+		{
+			name:     "Empty",
+			existing: []int{},
+			want:     []int{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := setUp(t, tc.existing)
+			pq.Heapify()
+			expectValues(t, pq, tc.want)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package algos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// ResourceBased picks a random alive and ready backend, with a probability
+// proportional to the free capacity the backend reported, either through
+// the LoadHeader of its responses or through its health check body.
+type ResourceBased struct {
+	backends *backendSet
+	beCfg    *pb.BackendConfig
+	// random returns a number in [0, 1), replaceable for tests.
+	random func() float64
+	mu     sync.RWMutex
+}
+
+func newResourceBasedWithBackends(backends []*Backend) *ResourceBased {
+	return &ResourceBased{
+		backends: newBackendSet(backends),
+		random:   rand.Float64,
+	}
+}
+
+func NewResourceBased(beCfg *pb.BackendConfig) (*ResourceBased, error) {
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	rb := newResourceBasedWithBackends(backends)
+	rb.beCfg = beCfg
+	return rb, nil
+}
+
+func (rb *ResourceBased) Register(rawURL string) error {
+	newBe, err := NewBackendWithConfig(rawURL, rb.beCfg)
+	if err != nil {
+		return err
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.backends.add(newBe) {
+		log.Printf("%v already registered", rawURL)
+	}
+	return nil
+}
+
+func (rb *ResourceBased) Deregister(rawURL string) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.backends.remove(rawURL) {
+		return fmt.Errorf("Tried to remove unknown backend %v", rawURL)
+	}
+	return nil
+}
+
+func capacity(be *Backend) float64 {
+	load, reported := be.Load()
+	if !reported {
+		load = unknownLoad
+	}
+	return load.freeCapacity()
+}
+
+func (rb *ResourceBased) nextBackend(r *http.Request) *Backend {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+
+	var candidates []*Backend
+	var capacities []float64
+	total := 0.0
+	for _, be := range rb.backends.backends {
+		if !be.availableFor(r) {
+			continue
+		}
+		candidates = append(candidates, be)
+		capacities = append(capacities, capacity(be))
+		total += capacities[len(capacities)-1]
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	pick := rb.random() * total
+	for i, be := range candidates {
+		pick -= capacities[i]
+		if pick < 0 {
+			return be
+		}
+	}
+	// Rounding errors may leave a tiny remainder
+	return candidates[len(candidates)-1]
+}
+
+func (rb *ResourceBased) Handler(r *http.Request) http.Handler {
+	return openConnection(r, rb.nextBackend)
+}
+
+// Lookup returns the registered backend with the given URL, or nil.
+func (rb *ResourceBased) Lookup(rawURL string) *Backend {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.backends.get(rawURL)
+}
+
+func (rb *ResourceBased) RegisterCheck(ctx context.Context, chk *Checker) {
+	chk.beSupplier = func() []*Backend {
+		rb.mu.RLock()
+		defer rb.mu.RUnlock()
+		return rb.backends.values()
+	}
+
+	chk.runInBackground(ctx)
+}
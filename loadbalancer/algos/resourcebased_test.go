@@ -0,0 +1,83 @@
+package algos
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func readyBackendWithLoad(t *testing.T, idx int, load LoadReport) *Backend {
+	be := upAndReadyBackend(t, idx)
+	be.ReportLoad(load)
+	return be
+}
+
+func TestResourceBasedHandler(t *testing.T) {
+	idleBE := readyBackendWithLoad(t, 0, LoadReport{CPU: 0.1, Memory: 0.1})
+	busyBE := readyBackendWithLoad(t, 1, LoadReport{CPU: 0.9, Memory: 0.9, QueueDepth: 10})
+	unreadyIdleBE := unreadyBackend(t, 2)
+	unreadyIdleBE.ReportLoad(LoadReport{})
+
+	tests := []struct {
+		name     string
+		backends []*Backend
+		random   float64
+		wantBE   *Backend
+	}{
+		{
+			name:     "Low draw picks the first BE",
+			backends: []*Backend{busyBE, idleBE},
+			random:   0,
+			wantBE:   busyBE,
+		},
+		{
+			name:     "Idle BE gets most of the range",
+			backends: []*Backend{busyBE, idleBE},
+			random:   0.1,
+			wantBE:   idleBE,
+		},
+		{
+			name:     "Unready BEs are skipped",
+			backends: []*Backend{unreadyIdleBE, busyBE},
+			random:   0,
+			wantBE:   busyBE,
+		},
+		{
+			name:     "Unready BEs are skipped, returns nil",
+			backends: []*Backend{unreadyIdleBE},
+			random:   0,
+			wantBE:   nil,
+		},
+		{
+			name:     "No BEs, returns nil",
+			backends: []*Backend{},
+			random:   0,
+			wantBE:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rb := newResourceBasedWithBackends(test.backends)
+			rb.random = func() float64 { return test.random }
+			if be := rb.nextBackend(nil); be != test.wantBE {
+				t.Errorf("want %v, got %v", test.wantBE, be)
+			}
+		})
+	}
+}
+
+func TestResourceBasedPrefersFreeCapacity(t *testing.T) {
+	idleBE := readyBackendWithLoad(t, 0, LoadReport{CPU: 0.1, Memory: 0.2})
+	busyBE := readyBackendWithLoad(t, 1, LoadReport{CPU: 0.8, Memory: 0.7, QueueDepth: 4})
+	rb := newResourceBasedWithBackends([]*Backend{idleBE, busyBE})
+	rb.random = rand.New(rand.NewSource(1)).Float64
+
+	picks := make(map[*Backend]int)
+	for i := 0; i < 1000; i++ {
+		picks[rb.nextBackend(nil)]++
+	}
+	if picks[idleBE] <= 10*picks[busyBE] {
+		t.Errorf("want the idle backend picked far more often, got idle %v, busy %v",
+			picks[idleBE], picks[busyBE])
+	}
+}
@@ -22,32 +22,32 @@ type RoundRobin struct {
 	beCount     int64
 	idx         int64
 	backoff     *Backoff
-	mu          sync.RWMutex
+	beCfg       *pb.BackendConfig
+	// weighted switches to smooth weighted round robin, where
+	// currentWeights holds the running weight of each backend.
+	weighted       bool
+	currentWeights []int64
+	mu             sync.RWMutex
 }
 
 func NewRoundRobin(beCfg *pb.BackendConfig) (*RoundRobin, error) {
-	var rawURLs []string
-	if beCfg.GetStatic() != nil {
-		rawURLs = beCfg.GetStatic().GetUrls()
+	backends, err := staticBackends(beCfg)
+	if err != nil {
+		return nil, err
 	}
 
-	backends := make([]*Backend, len(rawURLs))
 	beIndices := make(map[string]int)
-
-	var err error
-	for i, rawURL := range rawURLs {
-		beIndices[rawURL] = i
-
-		if backends[i], err = NewBackend(rawURL); err != nil {
-			return nil, err
-		}
+	for i, be := range backends {
+		beIndices[be.URL()] = i
 	}
 
 	return &RoundRobin{
-		idx:       -1,
-		backends:  backends,
-		beIndices: beIndices,
-		beCount:   int64(len(backends)),
+		idx:            -1,
+		backends:       backends,
+		beIndices:      beIndices,
+		beCount:        int64(len(backends)),
+		currentWeights: make([]int64, len(backends)),
+		beCfg:          beCfg,
 		// TODO consider making all these (and max backoffs) configurable
 		backoff: NewBackoff(
 			300*time.Millisecond, // initial sleep
@@ -58,7 +58,23 @@ func NewRoundRobin(beCfg *pb.BackendConfig) (*RoundRobin, error) {
 	}, nil
 }
 
+// NewWeightedRoundRobin spreads the requests proportionally to the backend
+// weights, interleaving them the way nginx does: weights 5, 1, 1 give the
+// sequence a, a, b, a, c, a, a instead of five requests to a in a row.
+func NewWeightedRoundRobin(beCfg *pb.BackendConfig) (*RoundRobin, error) {
+	rr, err := NewRoundRobin(beCfg)
+	if err != nil {
+		return nil, err
+	}
+	rr.weighted = true
+	return rr, nil
+}
+
 func (rr *RoundRobin) Register(rawURL string) error {
+	return rr.RegisterWeighted(rawURL, 1)
+}
+
+func (rr *RoundRobin) RegisterWeighted(rawURL string, weight int32) error {
 	rr.mu.Lock()
 	defer rr.mu.Unlock()
 	if _, present := rr.beIndices[rawURL]; present {
@@ -66,10 +82,11 @@ func (rr *RoundRobin) Register(rawURL string) error {
 		return nil
 	}
 
-	be, err := NewBackend(rawURL)
+	be, err := NewBackendWithConfig(rawURL, rr.beCfg)
 	if err != nil {
 		return err
 	}
+	be.SetWeight(weight)
 	if rr.idx >= 0 {
 		rr.idx = rr.idx % rr.beCount // make sure the algorithm is fair
 	}
@@ -78,8 +95,9 @@ func (rr *RoundRobin) Register(rawURL string) error {
 	}
 
 	rr.backends = append(rr.backends, be)
+	rr.currentWeights = append(rr.currentWeights, 0)
 	rr.beCount++
-	rr.beIndices[rawURL] = len(rr.backends)
+	rr.beIndices[rawURL] = len(rr.backends) - 1
 	return nil
 }
 
@@ -90,6 +108,7 @@ func (rr *RoundRobin) Deregister(url string) error {
 	if !present {
 		return fmt.Errorf("Tried to remove unknown backend %v", url)
 	}
+	rr.backends[beIndex].closeIdleConnections()
 
 	currIdx := rr.idx % rr.beCount
 	if currIdx <= int64(beIndex) && int64(beIndex) != (rr.beCount-1) {
@@ -101,12 +120,68 @@ func (rr *RoundRobin) Deregister(url string) error {
 	}
 	rr.beCount--
 	rr.backends = append(rr.backends[:beIndex], rr.backends[beIndex+1:]...)
+	if len(rr.currentWeights) > beIndex {
+		rr.currentWeights = append(rr.currentWeights[:beIndex], rr.currentWeights[beIndex+1:]...)
+	}
 
 	delete(rr.beIndices, url)
+	for i := beIndex; i < len(rr.backends); i++ {
+		rr.beIndices[rr.backends[i].URL()] = i
+	}
 	return nil
 }
 
+// nextWeighted picks a backend with nginx's smooth weighted round robin:
+// each pick raises the current weight of every available backend by its
+// weight, then the backend with the highest current weight is chosen and
+// lowered by the sum of the weights.
+func (rr *RoundRobin) nextWeighted(r *http.Request) *Backend {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	best := -1
+	total := int64(0)
+	for i, be := range rr.backends {
+		if !be.availableFor(r) {
+			continue
+		}
+		weight := int64(be.Weight())
+		rr.currentWeights[i] += weight
+		total += weight
+		if best < 0 || rr.currentWeights[i] > rr.currentWeights[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	rr.currentWeights[best] -= total
+	return rr.backends[best]
+}
+
+func (rr *RoundRobin) weightedHandler(r *http.Request) http.Handler {
+	rr.mu.RLock()
+	noBackends := rr.beCount == 0
+	rr.mu.RUnlock()
+	if noBackends {
+		return UnavailableHandler{}
+	}
+	for backOffs := 0; ; backOffs++ {
+		if be := rr.nextWeighted(r); be != nil {
+			if connection, ready := be.GetOpenConnection(r); ready {
+				return connection
+			}
+		}
+		if backOffs == maxBackofs || hasExclusions(r) {
+			return UnavailableHandler{}
+		}
+		rr.backoff.WaitABit()
+	}
+}
+
 func (rr *RoundRobin) Handler(r *http.Request) http.Handler {
+	if rr.weighted {
+		return rr.weightedHandler(r)
+	}
 	rr.mu.RLock()
 	defer rr.mu.RUnlock()
 	if rr.beCount == 0 {
@@ -122,7 +197,7 @@ func (rr *RoundRobin) Handler(r *http.Request) http.Handler {
 		}
 		tries++
 		if tries%rr.beCount == 0 {
-			if backOffs == maxBackofs {
+			if backOffs == maxBackofs || hasExclusions(r) {
 				return UnavailableHandler{}
 			}
 			rr.backoff.WaitABit()
@@ -131,11 +206,22 @@ func (rr *RoundRobin) Handler(r *http.Request) http.Handler {
 	}
 }
 
+// Lookup returns the registered backend with the given URL, or nil.
+func (rr *RoundRobin) Lookup(rawURL string) *Backend {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	if idx, present := rr.beIndices[rawURL]; present {
+		return rr.backends[idx]
+	}
+	return nil
+}
+
 func (rr *RoundRobin) RegisterCheck(ctx context.Context, chk *Checker) {
 	chk.beSupplier = func() []*Backend {
 		rr.mu.RLock()
 		defer rr.mu.RUnlock()
-		return rr.backends
+		// Deregister shifts the backends in place, the checks get a copy.
+		return append([]*Backend(nil), rr.backends...)
 	}
 
 	chk.runInBackground(ctx)
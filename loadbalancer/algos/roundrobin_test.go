@@ -1,9 +1,13 @@
 package algos
 
 import (
+	"context"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestRRHandler(t *testing.T) {
@@ -54,6 +58,27 @@ func TestRRHandler(t *testing.T) {
 	}
 }
 
+func TestRRSkipsExcluded(t *testing.T) {
+	first, second := upAndReadyBackend(t, 0), upAndReadyBackend(t, 1)
+	rr := &RoundRobin{
+		backends: []*Backend{first, second},
+		idx:      -1,
+		beCount:  2,
+	}
+	r := WithExcluded(httptest.NewRequest("GET", "/", nil), map[*Backend]bool{first: true})
+	for i := 0; i < 2; i++ {
+		if got := BackendOf(rr.Handler(r)); got != second {
+			t.Errorf("Handler() want the backend not excluded, got %v", got)
+		}
+	}
+
+	// No backoff (rr.backoff is nil) when only excluded backends are left.
+	r = WithExcluded(r, map[*Backend]bool{first: true, second: true})
+	if _, ok := rr.Handler(r).(UnavailableHandler); !ok {
+		t.Errorf("Handler() want UnavailableHandler when all the backends are excluded")
+	}
+}
+
 func TestRRRegister(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -210,3 +235,122 @@ func TestRRUnregister(t *testing.T) {
 		})
 	}
 }
+
+func weightedBackend(t *testing.T, idx int, weight int32) *Backend {
+	be := upAndReadyBackend(t, idx)
+	be.SetWeight(weight)
+	return be
+}
+
+func TestWeightedRRSequence(t *testing.T) {
+	a := weightedBackend(t, 0, 5)
+	b := weightedBackend(t, 1, 1)
+	c := weightedBackend(t, 2, 1)
+	unready := unreadyBackend(t, 3)
+	unready.SetWeight(10)
+
+	tests := []struct {
+		name     string
+		backends []*Backend
+		want     []*Backend
+	}{
+		{
+			name:     "Heavy backend is interleaved with the light ones",
+			backends: []*Backend{a, b, c},
+			want:     []*Backend{a, a, b, a, c, a, a},
+		},
+		{
+			name:     "Equal weights are plain round robin",
+			backends: []*Backend{b, c},
+			want:     []*Backend{b, c, b, c},
+		},
+		{
+			name:     "Unready backends are skipped",
+			backends: []*Backend{unready, a, b},
+			want:     []*Backend{a, a, a, b, a, a},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := &RoundRobin{
+				weighted:       true,
+				backends:       test.backends,
+				beCount:        int64(len(test.backends)),
+				currentWeights: make([]int64, len(test.backends)),
+			}
+			for i, want := range test.want {
+				if got := rr.nextWeighted(nil); got != want {
+					t.Errorf("pick %v: want %v, got %v", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRRRegister(t *testing.T) {
+	cfg := &pb.BackendConfig{
+		Type: &pb.BackendConfig_Static{
+			Static: &pb.StaticBackends{
+				Urls: []string{"http://localhost:8081"},
+				WeightedUrls: []*pb.WeightedUrl{
+					{Url: proto.String("http://localhost:8082"), Weight: proto.Int32(3)},
+				},
+			},
+		},
+	}
+	rr, err := NewWeightedRoundRobin(cfg)
+	if err != nil {
+		t.Fatalf("error creating weighted round robin algorithm %v", err)
+	}
+	if err := rr.RegisterWeighted("http://localhost:8083", 2); err != nil {
+		t.Fatalf("unexpected error registering: %v", err)
+	}
+	if err := rr.Deregister("http://localhost:8081"); err != nil {
+		t.Fatalf("unexpected error deregistering: %v", err)
+	}
+
+	wantWeights := map[string]int32{
+		"http://localhost:8082": 3,
+		"http://localhost:8083": 2,
+	}
+	if len(rr.backends) != len(wantWeights) || len(rr.currentWeights) != len(wantWeights) {
+		t.Fatalf("want %v backends and weights, got %v and %v",
+			len(wantWeights), len(rr.backends), len(rr.currentWeights))
+	}
+	for url, weight := range wantWeights {
+		idx, ok := rr.beIndices[url]
+		if !ok {
+			t.Errorf("want %v registered, got none", url)
+			continue
+		}
+		if got := rr.backends[idx]; got.URL() != url || got.Weight() != weight {
+			t.Errorf("backends[%v] want %v with weight %v, got %v with weight %v",
+				idx, url, weight, got.URL(), got.Weight())
+		}
+	}
+}
+
+func TestRRCheckGetsACopy(t *testing.T) {
+	urls := []string{"http://localhost:8081", "http://localhost:8082", "http://localhost:8083"}
+	rr, err := NewRoundRobin(&pb.BackendConfig{
+		Type: &pb.BackendConfig_Static{Static: &pb.StaticBackends{Urls: urls}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	chk := NewPoolChecker(func(context.Context, []*Backend) {}, time.Hour)
+	rr.RegisterCheck(ctx, chk)
+
+	checked := chk.beSupplier()
+	if err := rr.Deregister(urls[0]); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, be := range checked {
+		if be.URL() != urls[i] {
+			t.Errorf("checked backend %v want %v, got %v", i, urls[i], be.URL())
+		}
+	}
+}
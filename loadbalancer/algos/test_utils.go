@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func backendWithStatus(t *testing.T, status int32, idx int) *Backend {
@@ -25,9 +26,8 @@ func backendWithStatus(t *testing.T, status int32, idx int) *Backend {
 	}
 	url, _ := url.Parse(fmt.Sprintf("http://%v", srv.Addr))
 	return &Backend{
-		url:         url,
-		connections: []http.Handler{srv.Handler},
-		status:      status,
+		url:    url,
+		status: status,
 	}
 }
 
@@ -39,33 +39,52 @@ func upAndReadyBackend(t *testing.T, idx int) *Backend {
 	return backendWithStatus(t, aliveAndReady, idx)
 }
 
-func backendWithConnsAndStatus(t *testing.T, conns []http.Handler, status int32) *Backend {
+func backendWithConnsAndStatus(t *testing.T, conns int, status int32) *Backend {
 	t.Helper()
 
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
 		t.Fatalf("could not open port: %v", err)
 	}
-	srv := http.Server{
-		Addr: listener.Addr().String(),
-	}
-	if len(conns) > 0 {
-		srv.Handler = conns[0]
-	}
-	url, _ := url.Parse(fmt.Sprintf("http://%v", srv.Addr))
-	handlers := append(conns[1:], srv.Handler)
+	url, _ := url.Parse(fmt.Sprintf("http://%v", listener.Addr().String()))
 
 	return &Backend{
-		url:         url,
-		connections: handlers,
-		status:      status,
+		url:      url,
+		inFlight: int64(conns),
+		status:   status,
 	}
 }
 
-func unreadyBackendWithConnections(t *testing.T, conns []http.Handler) *Backend {
+func unreadyBackendWithConnections(t *testing.T, conns int) *Backend {
 	return backendWithConnsAndStatus(t, conns, aliveMask)
 }
 
-func readyBackendWithConnections(t *testing.T, conns []http.Handler) *Backend {
+func readyBackendWithConnections(t *testing.T, conns int) *Backend {
 	return backendWithConnsAndStatus(t, conns, aliveAndReady)
 }
+
+func readyBackendWithLatency(t *testing.T, idx int, latency time.Duration) *Backend {
+	be := upAndReadyBackend(t, idx)
+	be.observeLatency(latency, time.Now())
+	return be
+}
+
+// aliveBackendAt creates an alive and ready backend without a server behind it.
+func aliveBackendAt(t *testing.T, rawURL string) *Backend {
+	t.Helper()
+	be, err := NewBackend(rawURL)
+	if err != nil {
+		t.Fatalf("invalid backend url %v: %v", rawURL, err)
+	}
+	be.SetAlive(true)
+	return be
+}
+
+func aliveBackends(t *testing.T, count int) []*Backend {
+	t.Helper()
+	backends := make([]*Backend, count)
+	for i := range backends {
+		backends[i] = aliveBackendAt(t, fmt.Sprintf("http://backend-%d:8080", i))
+	}
+	return backends
+}
@@ -0,0 +1,64 @@
+package algos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TrafficStats sums up the proxied requests of a backend over a period.
+type TrafficStats struct {
+	Requests int64
+	// Failures are the 5xx responses, connection failures and timeouts.
+	Failures int64
+	// ConsecutiveFailures are the failures since the last success,
+	// which may span several periods.
+	ConsecutiveFailures int64
+	// MeanLatency is 0 if no request finished in the period.
+	MeanLatency time.Duration
+}
+
+// recordOutcome counts a proxied request for the traffic stats
+// and the circuit breaker.
+func (b *Backend) recordOutcome(success bool) {
+	atomic.AddInt64(&b.requests, 1)
+	if success {
+		atomic.StoreInt64(&b.consecutiveFailures, 0)
+	} else {
+		atomic.AddInt64(&b.failures, 1)
+		atomic.AddInt64(&b.consecutiveFailures, 1)
+	}
+	if b.breaker != nil {
+		b.breaker.record(success)
+	}
+}
+
+func (b *Backend) recordLatency(d time.Duration) {
+	atomic.AddInt64(&b.latencySum, int64(d))
+	atomic.AddInt64(&b.latencyCount, 1)
+}
+
+// TakeTrafficStats returns the stats since the previous call.
+func (b *Backend) TakeTrafficStats() TrafficStats {
+	stats := TrafficStats{
+		Requests:            atomic.SwapInt64(&b.requests, 0),
+		Failures:            atomic.SwapInt64(&b.failures, 0),
+		ConsecutiveFailures: atomic.LoadInt64(&b.consecutiveFailures),
+	}
+	latencySum := atomic.SwapInt64(&b.latencySum, 0)
+	if count := atomic.SwapInt64(&b.latencyCount, 0); count > 0 {
+		stats.MeanLatency = time.Duration(latencySum / count)
+	}
+	return stats
+}
+
+// Eject keeps the backend from being picked until the given time,
+// without deregistering it or changing its health.
+func (b *Backend) Eject(until time.Time) {
+	atomic.StoreInt64(&b.ejectedUntil, until.UnixNano())
+	atomic.StoreInt64(&b.consecutiveFailures, 0)
+}
+
+// IsEjected reports if the backend is ejected at the given time.
+func (b *Backend) IsEjected(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&b.ejectedUntil)
+}
@@ -0,0 +1,55 @@
+package algos
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Defaults for the connection pool of a backend, mostly the same as
+// http.DefaultTransport, except it keeps more than 2 idle connections.
+const (
+	defaultMaxIdleConns          = 100
+	defaultIdleTimeout           = 90 * time.Second
+	defaultDialTimeout           = 30 * time.Second
+	defaultResponseHeaderTimeout = 0 // no timeout
+	keepAlivePeriod              = 30 * time.Second
+	tlsHandshakeTimeout          = 10 * time.Second
+	expectContinueTimeout        = 1 * time.Second
+)
+
+func durationOr(d *durationpb.Duration, fallback time.Duration) time.Duration {
+	if d == nil {
+		return fallback
+	}
+	return d.AsDuration()
+}
+
+// newTransport creates the transport a backend keeps its connections in.
+// Every backend has its own transport, so all the limits are per backend.
+func newTransport(pool *pb.ConnectionPool) *http.Transport {
+	maxIdle := int(pool.GetMaxIdleConns())
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+	dialer := &net.Dialer{
+		Timeout:   durationOr(pool.GetDialTimeout(), defaultDialTimeout),
+		KeepAlive: keepAlivePeriod,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdle,
+		MaxConnsPerHost:       int(pool.GetMaxConns()),
+		IdleConnTimeout:       durationOr(pool.GetIdleTimeout(), defaultIdleTimeout),
+		ResponseHeaderTimeout: durationOr(pool.GetResponseHeaderTimeout(), defaultResponseHeaderTimeout),
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+	}
+}
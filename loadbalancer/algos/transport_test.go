@@ -0,0 +1,111 @@
+package algos
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestNewTransport(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		tr := newTransport(nil)
+		if tr.MaxIdleConnsPerHost != defaultMaxIdleConns {
+			t.Errorf("MaxIdleConnsPerHost want %v, got %v", defaultMaxIdleConns, tr.MaxIdleConnsPerHost)
+		}
+		if tr.IdleConnTimeout != defaultIdleTimeout {
+			t.Errorf("IdleConnTimeout want %v, got %v", defaultIdleTimeout, tr.IdleConnTimeout)
+		}
+		if tr.MaxConnsPerHost != 0 || tr.ResponseHeaderTimeout != 0 {
+			t.Errorf("want no connection limit nor header timeout, got %v and %v",
+				tr.MaxConnsPerHost, tr.ResponseHeaderTimeout)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		tr := newTransport(&pb.ConnectionPool{
+			MaxIdleConns:          proto.Int32(8),
+			IdleTimeout:           durationpb.New(time.Minute),
+			DialTimeout:           durationpb.New(time.Second),
+			ResponseHeaderTimeout: durationpb.New(5 * time.Second),
+			MaxConns:              proto.Int32(32),
+		})
+		if tr.MaxIdleConns != 8 || tr.MaxIdleConnsPerHost != 8 {
+			t.Errorf("idle connections want 8, got %v and %v per host", tr.MaxIdleConns, tr.MaxIdleConnsPerHost)
+		}
+		if tr.IdleConnTimeout != time.Minute {
+			t.Errorf("IdleConnTimeout want %v, got %v", time.Minute, tr.IdleConnTimeout)
+		}
+		if tr.ResponseHeaderTimeout != 5*time.Second {
+			t.Errorf("ResponseHeaderTimeout want %v, got %v", 5*time.Second, tr.ResponseHeaderTimeout)
+		}
+		if tr.MaxConnsPerHost != 32 {
+			t.Errorf("MaxConnsPerHost want 32, got %v", tr.MaxConnsPerHost)
+		}
+	})
+}
+
+// dynamicAlgorithm is the part of the algorithms used to add and remove
+// backends at runtime.
+type dynamicAlgorithm interface {
+	Register(rawURL string) error
+	Deregister(rawURL string) error
+	Handler(r *http.Request) http.Handler
+	Lookup(rawURL string) *Backend
+}
+
+func TestDeregisterClosesIdleConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		newAlgo func(beCfg *pb.BackendConfig) (dynamicAlgorithm, error)
+	}{
+		{"RoundRobin", func(beCfg *pb.BackendConfig) (dynamicAlgorithm, error) { return NewRoundRobin(beCfg) }},
+		{"LeastConnections", func(beCfg *pb.BackendConfig) (dynamicAlgorithm, error) { return NewLeastConnections(beCfg) }},
+		{"LowestLatency", func(beCfg *pb.BackendConfig) (dynamicAlgorithm, error) { return NewLowestLatency(beCfg) }},
+		{"ConsistentHash", func(beCfg *pb.BackendConfig) (dynamicAlgorithm, error) { return NewConsistentHash(beCfg) }},
+		{"Maglev", func(beCfg *pb.BackendConfig) (dynamicAlgorithm, error) { return NewMaglev(beCfg) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			closed := make(chan struct{}, 1)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateClosed {
+					closed <- struct{}{}
+				}
+			}
+			server.Start()
+			defer server.Close()
+
+			algo, err := test.newAlgo(&pb.BackendConfig{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err := algo.Register(server.URL); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			algo.Lookup(server.URL).SetAlive(true)
+			req := httptest.NewRequest("GET", "/", nil)
+			resp := httptest.NewRecorder()
+			algo.Handler(req).ServeHTTP(resp, req)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("want status OK, got %v", resp.Code)
+			}
+
+			if err := algo.Deregister(server.URL); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Errorf("want the idle connection closed after deregistering")
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package algos
+
+import "time"
+
+// windowBuckets is how many buckets a sliding window is split into,
+// the window slides one bucket at a time.
+const windowBuckets = 10
+
+// WindowCounter counts the requests, and some events like their failures
+// or retries, over a sliding window. It is not safe for concurrent use,
+// callers must hold their own lock.
+type WindowCounter struct {
+	bucketSize time.Duration
+	requests   [windowBuckets]int64
+	events     [windowBuckets]int64
+	// head is the number of the latest bucket, counted since the epoch.
+	head int64
+}
+
+// NewWindowCounter creates a counter over the given window, which callers
+// check is long enough to be split into buckets.
+func NewWindowCounter(window time.Duration) *WindowCounter {
+	return &WindowCounter{bucketSize: window / windowBuckets}
+}
+
+// advance moves the window to now, clearing the buckets that fell out of
+// it, and returns the index of the current bucket.
+func (c *WindowCounter) advance(now time.Time) int {
+	current := now.UnixNano() / int64(c.bucketSize)
+	if current-c.head >= windowBuckets {
+		c.Reset()
+	} else {
+		for bucket := c.head + 1; bucket <= current; bucket++ {
+			c.requests[bucket%windowBuckets] = 0
+			c.events[bucket%windowBuckets] = 0
+		}
+	}
+	if current > c.head {
+		c.head = current
+	}
+	return int(c.head % windowBuckets)
+}
+
+// Add counts requests and events at now.
+func (c *WindowCounter) Add(now time.Time, requests, events int64) {
+	bucket := c.advance(now)
+	c.requests[bucket] += requests
+	c.events[bucket] += events
+}
+
+// Sums returns the requests and events in the window ending at now.
+func (c *WindowCounter) Sums(now time.Time) (requests int64, events int64) {
+	c.advance(now)
+	for i := 0; i < windowBuckets; i++ {
+		requests += c.requests[i]
+		events += c.events[i]
+	}
+	return requests, events
+}
+
+// Reset clears all the counts.
+func (c *WindowCounter) Reset() {
+	c.requests, c.events = [windowBuckets]int64{}, [windowBuckets]int64{}
+}
@@ -0,0 +1,35 @@
+package algos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowCounter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name         string
+		sumAt        time.Duration
+		wantRequests int64
+		wantEvents   int64
+	}{
+		{name: "All in the window", sumAt: 5 * time.Second, wantRequests: 3, wantEvents: 1},
+		{name: "Oldest bucket slid out", sumAt: 10 * time.Second, wantRequests: 2, wantEvents: 1},
+		{name: "All slid out", sumAt: 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := NewWindowCounter(10 * time.Second)
+			counter.Add(start, 1, 0)
+			counter.Add(start.Add(2*time.Second), 1, 1)
+			counter.Add(start.Add(3*time.Second), 1, 0)
+
+			requests, events := counter.Sums(start.Add(test.sumAt))
+			if requests != test.wantRequests || events != test.wantEvents {
+				t.Errorf("want %v requests and %v events, got %v and %v",
+					test.wantRequests, test.wantEvents, requests, events)
+			}
+		})
+	}
+}
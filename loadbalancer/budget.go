@@ -0,0 +1,126 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const (
+	defaultBudgetRatio     = 0.2
+	defaultBudgetMinPerSec = 10
+	defaultBudgetWindow    = 10 * time.Second
+)
+
+// retryBudget counts the requests and retries of a pool over a sliding
+// window, allowing retries while they stay under a share of the requests.
+type retryBudget struct {
+	ratio float64
+	// minRetries are the retries allowed in a window regardless of the ratio.
+	minRetries float64
+	now        func() time.Time
+
+	mu sync.Mutex
+	// window counts the requests, retries excluded, and the retries.
+	window *algos.WindowCounter
+	// retried and rejected count the retries sent and denied since the start.
+	retried  int64
+	rejected int64
+}
+
+func newRetryBudget(budgetCfg *pb.RetryBudget) (*retryBudget, error) {
+	if budgetCfg == nil {
+		budgetCfg = &pb.RetryBudget{}
+	}
+	ratio := defaultBudgetRatio
+	if budgetCfg.Ratio != nil {
+		if budgetCfg.GetRatio() < 0 {
+			return nil, fmt.Errorf("retry budget ratio must not be negative, got %v", budgetCfg.GetRatio())
+		}
+		ratio = budgetCfg.GetRatio()
+	}
+	minPerSec := float64(defaultBudgetMinPerSec)
+	if budgetCfg.MinRetriesPerSecond != nil {
+		if budgetCfg.GetMinRetriesPerSecond() < 0 {
+			return nil, fmt.Errorf("retry budget min retries per second must not be negative, got %v",
+				budgetCfg.GetMinRetriesPerSecond())
+		}
+		minPerSec = budgetCfg.GetMinRetriesPerSecond()
+	}
+	window := defaultBudgetWindow
+	if budgetCfg.GetWindow() != nil {
+		window = budgetCfg.GetWindow().AsDuration()
+		if window < time.Second {
+			return nil, fmt.Errorf("retry budget window must be at least 1s, got %v", window)
+		}
+	}
+	return &retryBudget{
+		ratio:      ratio,
+		minRetries: minPerSec * window.Seconds(),
+		window:     algos.NewWindowCounter(window),
+		now:        time.Now,
+	}, nil
+}
+
+// remaining returns how many retries the budget allows. b.mu must be held.
+func (b *retryBudget) remaining() float64 {
+	requests, retries := b.window.Sums(b.now())
+	if left := b.ratio*float64(requests) + b.minRetries - float64(retries); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// request counts a request sent to the pool, retries excluded.
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.window.Add(b.now(), 1, 0)
+}
+
+// canRetry reports if the budget has room for one more retry.
+func (b *retryBudget) canRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining() >= 1
+}
+
+// retry counts a retry sent to the pool.
+func (b *retryBudget) retry() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.window.Add(b.now(), 0, 1)
+	b.retried++
+}
+
+// reject counts a retry denied by the budget.
+func (b *retryBudget) reject() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejected++
+}
+
+// budgetStats is a snapshot of a retry budget, for the metrics.
+type budgetStats struct {
+	requests  int64
+	retries   int64
+	remaining float64
+	retried   int64
+	rejected  int64
+}
+
+func (b *retryBudget) stats() budgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests, retries := b.window.Sums(b.now())
+	return budgetStats{
+		requests:  requests,
+		retries:   retries,
+		remaining: b.remaining(),
+		retried:   b.retried,
+		rejected:  b.rejected,
+	}
+}
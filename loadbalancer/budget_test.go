@@ -0,0 +1,116 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryBudget(t *testing.T) {
+	budget, err := newRetryBudget(&pb.RetryBudget{
+		Ratio:               proto.Float64(0.2),
+		MinRetriesPerSecond: proto.Float64(0),
+		Window:              durationpb.New(10 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	now := time.Unix(1000, 0)
+	budget.now = func() time.Time { return now }
+
+	if budget.canRetry() {
+		t.Errorf("want no retries without requests")
+	}
+	for i := 0; i < 10; i++ {
+		budget.request()
+	}
+	for i := 0; i < 2; i++ {
+		if !budget.canRetry() {
+			t.Fatalf("want retry %v allowed after 10 requests", i+1)
+		}
+		budget.retry()
+	}
+	if budget.canRetry() {
+		t.Errorf("want the third retry denied after 10 requests")
+	}
+
+	// Only the requests of the last 10 seconds are counted.
+	now = now.Add(9 * time.Second)
+	for i := 0; i < 5; i++ {
+		budget.request()
+	}
+	now = now.Add(2 * time.Second)
+	stats := budget.stats()
+	if stats.requests != 5 || stats.retries != 0 || stats.retried != 2 {
+		t.Errorf("want 5 requests, 0 retries and 2 retried, got %+v", stats)
+	}
+	if !budget.canRetry() {
+		t.Errorf("want a retry allowed once the window moved")
+	}
+}
+
+func TestRetryBudgetMinRetries(t *testing.T) {
+	budget, err := newRetryBudget(&pb.RetryBudget{
+		Ratio:               proto.Float64(0),
+		MinRetriesPerSecond: proto.Float64(0.5),
+		Window:              durationpb.New(4 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if !budget.canRetry() {
+			t.Fatalf("want retry %v allowed by the minimum", i+1)
+		}
+		budget.retry()
+	}
+	if budget.canRetry() {
+		t.Errorf("want the third retry denied")
+	}
+}
+
+func TestRetryBudgetRejects(t *testing.T) {
+	be := newCountingBackend(http.StatusServiceUnavailable, 0)
+	defer be.server.Close()
+	pool := retryPool(t, &pb.RetryPolicy{
+		Budget: &pb.RetryBudget{Ratio: proto.Float64(0), MinRetriesPerSecond: proto.Float64(0)},
+	}, be.server.URL)
+
+	resp := httptest.NewRecorder()
+	pool.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %v, got %v", http.StatusServiceUnavailable, resp.Code)
+	}
+	if attempts := len(be.received()); attempts != 1 {
+		t.Errorf("want 1 attempt, got %v", attempts)
+	}
+	stats := pool.retry.budget.stats()
+	if stats.requests != 1 || stats.rejected != 1 {
+		t.Errorf("want 1 request and 1 rejected retry, got %+v", stats)
+	}
+}
+
+func TestNewRetryBudgetErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget *pb.RetryBudget
+	}{
+		{name: "Negative ratio", budget: &pb.RetryBudget{Ratio: proto.Float64(-1)}},
+		{name: "Negative minimum", budget: &pb.RetryBudget{MinRetriesPerSecond: proto.Float64(-1)}},
+		{name: "Short window", budget: &pb.RetryBudget{Window: durationpb.New(time.Millisecond)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newRetryBudget(test.budget); err == nil {
+				t.Errorf("newRetryBudget() want error, got nil")
+			}
+		})
+	}
+}
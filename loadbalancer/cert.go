@@ -16,27 +16,31 @@ import (
 	"golang.org/x/crypto/acme/autocert"
 )
 
-func (s *Server) setTLSFromLocalFiles(localCfg *pb.LocalCert) error {
+// certGetter returns the certificate to present in a TLS handshake.
+type certGetter func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+func certFromLocalFiles(localCfg *pb.LocalCert) (certGetter, error) {
 	cert := localCfg.GetCertPath()
 	key := localCfg.GetPrivateKeyPath()
 
 	if len(cert) == 0 || len(key) == 0 {
-		return fmt.Errorf("Local setup must specify the certificate and key path")
+		return nil, fmt.Errorf("Local setup must specify the certificate and key path")
 	}
 	cer, err := tls.LoadX509KeyPair(cert, key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	s.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cer}}
-	return nil
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &cer, nil
+	}, nil
 }
 
-func (s *Server) setAutomaticTLS(acmeCfg *pb.AcmeCert) error {
+func automaticCert(acmeCfg *pb.AcmeCert) (certGetter, error) {
 	domain := acmeCfg.GetDomain()
 	serverDirURL := acmeCfg.GetServerDir()
 	if len(domain) == 0 || len(serverDirURL) == 0 {
-		return fmt.Errorf("Automatic certificate management requires the domain and the server directory to be set.")
+		return nil, fmt.Errorf("Automatic certificate management requires the domain and the server directory to be set.")
 	}
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -57,24 +61,89 @@ func (s *Server) setAutomaticTLS(acmeCfg *pb.AcmeCert) error {
 		manager.Cache = autocert.DirCache(acmeCfg.GetCacheDirectory())
 	}
 
-	tlsConfig := manager.TLSConfig()
-	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 		cert, err := manager.GetCertificate(hello)
 		if err != nil {
 			log.Printf("Error getting certificates %v", err)
 		}
 		return cert, err
+	}, nil
+}
+
+func newCertGetter(certCfg *pb.CertConfig) (certGetter, error) {
+	if certCfg.GetAcme() != nil {
+		return automaticCert(certCfg.GetAcme())
 	}
-	s.server.TLSConfig = tlsConfig
-	return nil
+	return certFromLocalFiles(certCfg.GetLocal())
 }
 
-// load TLS configuration
+// hasCerts reports if the load balancer or any of its virtual hosts
+// has a certificate configured.
+func (s *Server) hasCerts() bool {
+	if s.cfg.GetCert() != nil {
+		return true
+	}
+	for _, vhost := range s.cfg.GetVirtualHosts() {
+		if vhost.GetCert() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// usesAcme reports if any of the certificates is managed through ACME.
+func (s *Server) usesAcme() bool {
+	if s.cfg.GetCert().GetAcme() != nil {
+		return true
+	}
+	for _, vhost := range s.cfg.GetVirtualHosts() {
+		if vhost.GetCert().GetAcme() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// load TLS configuration, picking the certificate of the virtual host
+// asked for through SNI and falling back to the load balancer one.
 func (s *Server) SetupTLS(ctx context.Context) error {
-	certCfg := s.cfg.GetCert()
+	var defaultCert certGetter
+	if s.cfg.GetCert() != nil {
+		var err error
+		if defaultCert, err = newCertGetter(s.cfg.GetCert()); err != nil {
+			return err
+		}
+	}
 
-	if certCfg.GetAcme() != nil {
-		return s.setAutomaticTLS(certCfg.GetAcme())
+	hostCerts := newHostMatcher[certGetter]()
+	for _, vhost := range s.cfg.GetVirtualHosts() {
+		if vhost.GetCert() == nil {
+			continue
+		}
+		getCert, err := newCertGetter(vhost.GetCert())
+		if err != nil {
+			return fmt.Errorf("error loading the certificate of %v: %v", vhost.GetDomains(), err)
+		}
+		for _, domain := range vhost.GetDomains() {
+			if err := hostCerts.add(domain, getCert); err != nil {
+				return err
+			}
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if getCert, ok := hostCerts.match(hello.ServerName); ok {
+				return getCert(hello)
+			} else if defaultCert != nil {
+				return defaultCert(hello)
+			}
+			return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+		},
 	}
-	return s.setTLSFromLocalFiles(certCfg.GetLocal())
+	if s.usesAcme() {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+	s.server.TLSConfig = tlsConfig
+	return nil
 }
@@ -0,0 +1,87 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultMaintenance is served in maintenance mode when no response is configured.
+var defaultMaintenance = &pb.DirectResponse{
+	Status: proto.Int32(http.StatusServiceUnavailable),
+	Body:   proto.String("Service under maintenance\n"),
+}
+
+// directResponse answers requests with a response from the config.
+type directResponse struct {
+	status  int
+	headers map[string]string
+	body    []byte
+}
+
+func newDirectResponse(respCfg *pb.DirectResponse) (*directResponse, error) {
+	status := http.StatusOK
+	if respCfg.Status != nil {
+		status = int(respCfg.GetStatus())
+		if status < 100 || status > 999 {
+			return nil, fmt.Errorf("invalid direct response status %v", status)
+		}
+	}
+	return &directResponse{
+		status:  status,
+		headers: respCfg.GetHeaders(),
+		body:    []byte(respCfg.GetBody()),
+	}, nil
+}
+
+func (dr *directResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for name, value := range dr.headers {
+		w.Header().Set(name, value)
+	}
+	if len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(dr.status)
+	w.Write(dr.body)
+}
+
+// inMaintenance reports if the maintenance mode is on.
+func (s *Server) inMaintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) == 1
+}
+
+func (s *Server) setMaintenance(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&s.maintenance, value)
+}
+
+// ToggleMaintenance turns the maintenance mode on or off.
+func (s *Server) ToggleMaintenance(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received maintenance request")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	}
+	maintenanceReq := &pb.MaintenanceRequest{}
+	if err := proto.Unmarshal(body, maintenanceReq); err != nil {
+		log.Printf("Failed to parse maintenance request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	}
+
+	s.setMaintenance(maintenanceReq.GetEnabled())
+	log.Printf("Maintenance mode enabled: %v", maintenanceReq.GetEnabled())
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Maintenance updated"))
+}
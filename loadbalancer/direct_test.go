@@ -0,0 +1,149 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDirectResponseRoute(t *testing.T) {
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{},
+		Routes: []*pb.Route{
+			{
+				PathPrefix: proto.String("/robots.txt"),
+				DirectResponse: &pb.DirectResponse{
+					Headers: map[string]string{"Cache-Control": "max-age=3600"},
+					Body:    proto.String("User-agent: *\nDisallow: /\n"),
+				},
+			},
+			{
+				PathPrefix: proto.String("/legacy"),
+				DirectResponse: &pb.DirectResponse{
+					Status: proto.Int32(http.StatusGone),
+				},
+			},
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+		wantHeader string
+	}{
+		{path: "/robots.txt", wantStatus: http.StatusOK, wantBody: "User-agent: *\nDisallow: /\n", wantHeader: "max-age=3600"},
+		{path: "/legacy/page", wantStatus: http.StatusGone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			lb.ServeHTTP(resp, httptest.NewRequest("GET", test.path, nil))
+			if resp.Code != test.wantStatus || resp.Body.String() != test.wantBody {
+				t.Errorf("want %v %q, got %v %q", test.wantStatus, test.wantBody, resp.Code, resp.Body.String())
+			}
+			if got := resp.Header().Get("Cache-Control"); got != test.wantHeader {
+				t.Errorf("want Cache-Control %q, got %q", test.wantHeader, got)
+			}
+		})
+	}
+}
+
+func TestInvalidDirectResponse(t *testing.T) {
+	routeCfg := &pb.Route{
+		DirectResponse: &pb.DirectResponse{Status: proto.Int32(42)},
+	}
+	if _, err := newRoute(routeCfg, map[string]*backendPool{}); err == nil {
+		t.Errorf("newRoute() want error for an invalid status, got nil")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	backend := alwaysAliveBackend()
+	backend.startListen(t)
+	defer backend.stop(t)
+
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{
+			Type: &pb.BackendConfig_Static{
+				Static: &pb.StaticBackends{Urls: []string{backend.server.URL}},
+			},
+		},
+		Maintenance: &pb.Maintenance{
+			Enabled: proto.Bool(true),
+			Response: &pb.DirectResponse{
+				Status:  proto.Int32(http.StatusServiceUnavailable),
+				Headers: map[string]string{"Retry-After": "120", "Content-Type": "text/html"},
+				Body:    proto.String("<h1>Back soon</h1>"),
+			},
+			TogglePath: proto.String("/maintenance"),
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	lb.defaultPool.lbAlgo.(backendLookup).Lookup(backend.server.URL).SetAlive(true)
+	toggle := func(enabled bool) {
+		body, err := proto.Marshal(&pb.MaintenanceRequest{Enabled: proto.Bool(enabled)})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		resp := httptest.NewRecorder()
+		lb.server.Handler.ServeHTTP(resp, httptest.NewRequest("POST", "/maintenance", bytes.NewBuffer(body)))
+		if resp.Code != http.StatusOK {
+			t.Fatalf("toggling maintenance want status OK, got %v", resp.Code)
+		}
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		lb.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+		return resp
+	}
+
+	resp := serve("/hello")
+	if resp.Code != http.StatusServiceUnavailable || resp.Body.String() != "<h1>Back soon</h1>" ||
+		resp.Header().Get("Retry-After") != "120" || resp.Header().Get("Content-Type") != "text/html" {
+		t.Errorf("want the maintenance page, got %v %v %q", resp.Code, resp.Header(), resp.Body.String())
+	}
+	if resp := serve("/healthz"); resp.Code != http.StatusOK {
+		t.Errorf("want /healthz to stay alive in maintenance, got %v", resp.Code)
+	}
+
+	toggle(false)
+	if resp := serve("/hello"); resp.Code != http.StatusOK {
+		t.Errorf("want the backend to answer out of maintenance, got %v", resp.Code)
+	}
+	if backend.requestsReceived != 1 {
+		t.Errorf("backend got %v requests, want 1", backend.requestsReceived)
+	}
+
+	toggle(true)
+	if resp := serve("/hello"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("want maintenance back on, got %v", resp.Code)
+	}
+}
+
+func TestDefaultMaintenancePage(t *testing.T) {
+	lb, err := New(&pb.Config{
+		Backend:     &pb.BackendConfig{},
+		Maintenance: &pb.Maintenance{Enabled: proto.Bool(true)},
+	})
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	resp := httptest.NewRecorder()
+	lb.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	if resp.Code != http.StatusServiceUnavailable || resp.Body.String() != defaultMaintenance.GetBody() {
+		t.Errorf("want the default maintenance page, got %v %q", resp.Code, resp.Body.String())
+	}
+}
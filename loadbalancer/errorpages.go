@@ -0,0 +1,187 @@
+package loadbalancer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// requestIDHeader carries the ID of a request to the backends,
+// and back to the client on error pages.
+const requestIDHeader = "X-Request-Id"
+
+// validRequestID limits the IDs taken from clients, since they end up
+// in the error pages.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// ensureRequestID keeps the request ID sent by the client if it looks
+// valid, or sets a new random one.
+func ensureRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID.MatchString(id) {
+		return id
+	}
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	id := hex.EncodeToString(raw)
+	r.Header.Set(requestIDHeader, id)
+	return id
+}
+
+type errorBody struct {
+	contentType string
+	// mediaType is contentType without its parameters, e.g. charset.
+	mediaType string
+	body      string
+}
+
+type errorPage struct {
+	bodies []*errorBody
+}
+
+// errorPages are the error pages of a route or of the load balancer.
+type errorPages struct {
+	byStatus map[int]*errorPage
+	// forAll is the page without statuses, used for the other statuses.
+	forAll *errorPage
+}
+
+func newErrorPages(pagesCfg []*pb.ErrorPage) (*errorPages, error) {
+	if len(pagesCfg) == 0 {
+		return nil, nil
+	}
+	pages := &errorPages{
+		byStatus: make(map[int]*errorPage),
+	}
+	for _, pageCfg := range pagesCfg {
+		if len(pageCfg.GetBodies()) == 0 {
+			return nil, fmt.Errorf("error pages must have at least a body")
+		}
+		page := &errorPage{}
+		for _, bodyCfg := range pageCfg.GetBodies() {
+			mediaType, _, err := mime.ParseMediaType(bodyCfg.GetContentType())
+			if err != nil {
+				return nil, fmt.Errorf("invalid error page content type %q: %v", bodyCfg.GetContentType(), err)
+			}
+			page.bodies = append(page.bodies, &errorBody{
+				contentType: bodyCfg.GetContentType(),
+				mediaType:   mediaType,
+				body:        bodyCfg.GetBody(),
+			})
+		}
+
+		if len(pageCfg.GetStatuses()) == 0 {
+			if pages.forAll != nil {
+				return nil, fmt.Errorf("only one error page can be for all statuses")
+			}
+			pages.forAll = page
+		}
+		for _, status := range pageCfg.GetStatuses() {
+			if _, present := pages.byStatus[int(status)]; present {
+				return nil, fmt.Errorf("duplicate error page for status %v", status)
+			}
+			pages.byStatus[int(status)] = page
+		}
+	}
+	return pages, nil
+}
+
+// page returns the page for status, or nil if there is none.
+func (ep *errorPages) page(status int) *errorPage {
+	if ep == nil {
+		return nil
+	} else if page, present := ep.byStatus[status]; present {
+		return page
+	}
+	return ep.forAll
+}
+
+// acceptQuality returns the quality the Accept header gives to mediaType,
+// from its most specific matching range, or 0 if it is not acceptable.
+func acceptQuality(accept string, mediaType string) float64 {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, rawRange := range strings.Split(accept, ",") {
+		acceptRange, params, err := mime.ParseMediaType(strings.TrimSpace(rawRange))
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSubtype, _ := strings.Cut(acceptRange, "/")
+		rangeSpecificity := 0
+		switch {
+		case rangeType == typ && rangeSubtype == subtype:
+			rangeSpecificity = 2
+		case rangeType == typ && rangeSubtype == "*":
+			rangeSpecificity = 1
+		case rangeType == "*" && rangeSubtype == "*":
+			rangeSpecificity = 0
+		default:
+			continue
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+		specificity = rangeSpecificity
+		quality = 1
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+	}
+	return quality
+}
+
+// negotiate picks the body the Accept header prefers, the first
+// body if the request has no Accept header or accepts none of them.
+func (page *errorPage) negotiate(accept string) *errorBody {
+	if len(accept) == 0 {
+		return page.bodies[0]
+	}
+	best, bestQuality := page.bodies[0], 0.0
+	for _, body := range page.bodies {
+		if quality := acceptQuality(accept, body.mediaType); quality > bestQuality {
+			best, bestQuality = body, quality
+		}
+	}
+	return best
+}
+
+// errorWriter writes the load balancer errors with the first page found
+// for their status in pages, falling back to the default bodies.
+func errorWriter(pages ...*errorPages) algos.ErrorWriter {
+	return func(w http.ResponseWriter, r *http.Request, status int, defaultBody string) {
+		requestID := ensureRequestID(r)
+		w.Header().Set(requestIDHeader, requestID)
+		var page *errorPage
+		for _, candidate := range pages {
+			if page = candidate.page(status); page != nil {
+				break
+			}
+		}
+		if page == nil {
+			if len(defaultBody) > 0 {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(defaultBody))
+			return
+		}
+
+		body := page.negotiate(r.Header.Get("Accept"))
+		replacer := strings.NewReplacer(
+			"{{status}}", strconv.Itoa(status),
+			"{{status_text}}", http.StatusText(status),
+			"{{request_id}}", requestID,
+		)
+		w.Header().Set("Content-Type", body.contentType)
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(status)
+		w.Write([]byte(replacer.Replace(body.body)))
+	}
+}
@@ -0,0 +1,221 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestNegotiate(t *testing.T) {
+	pages, err := newErrorPages([]*pb.ErrorPage{
+		{
+			Bodies: []*pb.ErrorBody{
+				{ContentType: proto.String("text/html; charset=utf-8"), Body: proto.String("html")},
+				{ContentType: proto.String("application/json"), Body: proto.String("json")},
+				{ContentType: proto.String("text/plain"), Body: proto.String("plain")},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	page := pages.page(http.StatusBadGateway)
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "html"},
+		{accept: "application/json", want: "json"},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: "html"},
+		{accept: "application/json;q=0.5, text/plain", want: "plain"},
+		{accept: "text/*;q=0.3, application/*;q=0.4", want: "json"},
+		{accept: "*/*", want: "html"},
+		{accept: "text/plain;q=0, text/*", want: "html"},
+		{accept: "image/png", want: "html"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			if got := page.negotiate(test.accept); got.body != test.want {
+				t.Errorf("negotiate(%q) want %v, got %v", test.accept, test.want, got.body)
+			}
+		})
+	}
+}
+
+func TestNewErrorPagesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []*pb.ErrorPage
+	}{
+		{
+			name:  "No bodies",
+			pages: []*pb.ErrorPage{{Statuses: []int32{502}}},
+		},
+		{
+			name: "Invalid content type",
+			pages: []*pb.ErrorPage{
+				{Bodies: []*pb.ErrorBody{{ContentType: proto.String("not a/type/")}}},
+			},
+		},
+		{
+			name: "Duplicate status",
+			pages: []*pb.ErrorPage{
+				{Statuses: []int32{502}, Bodies: []*pb.ErrorBody{{ContentType: proto.String("text/plain")}}},
+				{Statuses: []int32{502}, Bodies: []*pb.ErrorBody{{ContentType: proto.String("text/html")}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newErrorPages(test.pages); err == nil {
+				t.Errorf("newErrorPages() want error, got nil")
+			}
+		})
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	jsonPage := &pb.ErrorPage{
+		Bodies: []*pb.ErrorBody{
+			{ContentType: proto.String("text/html"), Body: proto.String("<h1>{{status}} {{status_text}}</h1>")},
+			{
+				ContentType: proto.String("application/json"),
+				Body:        proto.String(`{"status":{{status}},"request_id":"{{request_id}}"}`),
+			},
+		},
+	}
+	staticPool := func(name string, url string) *pb.BackendPool {
+		return &pb.BackendPool{
+			Name: proto.String(name),
+			Backend: &pb.BackendConfig{
+				Type: &pb.BackendConfig_Static{Static: &pb.StaticBackends{Urls: []string{url}}},
+				ConnectionPool: &pb.ConnectionPool{
+					ResponseHeaderTimeout: durationpb.New(50 * time.Millisecond),
+				},
+			},
+		}
+	}
+	cfg := &pb.Config{
+		Pools: []*pb.BackendPool{
+			{Name: proto.String("empty"), Backend: &pb.BackendConfig{}},
+			staticPool("slow", slow.URL),
+			staticPool("closed", closed.URL),
+		},
+		Routes: []*pb.Route{
+			{PathPrefix: proto.String("/empty"), Pool: proto.String("empty")},
+			{PathPrefix: proto.String("/slow"), Pool: proto.String("slow")},
+			{
+				PathPrefix: proto.String("/closed"),
+				Pool:       proto.String("closed"),
+				ErrorPages: []*pb.ErrorPage{
+					{
+						Statuses: []int32{http.StatusBadGateway},
+						Bodies: []*pb.ErrorBody{
+							{ContentType: proto.String("text/plain"), Body: proto.String("route {{request_id}}")},
+						},
+					},
+				},
+			},
+		},
+		ErrorPages: []*pb.ErrorPage{jsonPage},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	lb.pools["slow"].lbAlgo.(backendLookup).Lookup(slow.URL).SetAlive(true)
+	lb.pools["closed"].lbAlgo.(backendLookup).Lookup(closed.URL).SetAlive(true)
+
+	tests := []struct {
+		name            string
+		path            string
+		accept          string
+		requestID       string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "No available backend as JSON",
+			path:            "/empty",
+			accept:          "application/json",
+			requestID:       "abc-123",
+			wantStatus:      http.StatusServiceUnavailable,
+			wantContentType: "application/json",
+			wantBody:        `{"status":503,"request_id":"abc-123"}`,
+		},
+		{
+			name:            "No available backend as HTML",
+			path:            "/empty",
+			accept:          "text/html",
+			wantStatus:      http.StatusServiceUnavailable,
+			wantContentType: "text/html",
+			wantBody:        "<h1>503 Service Unavailable</h1>",
+		},
+		{
+			name:            "Backend timeout",
+			path:            "/slow",
+			accept:          "text/html",
+			wantStatus:      http.StatusGatewayTimeout,
+			wantContentType: "text/html",
+			wantBody:        "<h1>504 Gateway Timeout</h1>",
+		},
+		{
+			name:            "Unreachable backend uses the route page",
+			path:            "/closed",
+			requestID:       "abc-123",
+			wantStatus:      http.StatusBadGateway,
+			wantContentType: "text/plain",
+			wantBody:        "route abc-123",
+		},
+		{
+			name:            "No route",
+			path:            "/other",
+			accept:          "application/json",
+			requestID:       "<script>",
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "application/json",
+			wantBody:        `{"status":404,"request_id":"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.path, nil)
+			req.Header.Set("Accept", test.accept)
+			req.Header.Set(requestIDHeader, test.requestID)
+			resp := httptest.NewRecorder()
+			lb.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantStatus {
+				t.Errorf("want status %v, got %v", test.wantStatus, resp.Code)
+			}
+			if got := resp.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("want content type %v, got %v", test.wantContentType, got)
+			}
+			if !strings.HasPrefix(resp.Body.String(), test.wantBody) {
+				t.Errorf("want body starting with %v, got %v", test.wantBody, resp.Body.String())
+			}
+			requestID := resp.Header().Get(requestIDHeader)
+			if !validRequestID.MatchString(requestID) || strings.Contains(resp.Body.String(), "<script>") {
+				t.Errorf("want a valid request ID, got %q in %q", requestID, resp.Body.String())
+			}
+		})
+	}
+}
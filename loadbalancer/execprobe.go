@@ -0,0 +1,97 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const (
+	defaultCommandTimeout = 10 * time.Second
+	// maxCommandOutput limits how much of the output of a probe command is kept.
+	maxCommandOutput = 4 << 10
+	// commandWaitDelay is how long to wait for the output of a probe
+	// command to be closed after it was killed.
+	commandWaitDelay = 100 * time.Millisecond
+)
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest,
+// so a chatty command cannot exhaust the memory.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (cb *cappedBuffer) Write(p []byte) (int, error) {
+	if room := cb.max - cb.buf.Len(); room > 0 {
+		if len(p) > room {
+			cb.buf.Write(p[:room])
+		} else {
+			cb.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// commandEnv returns the environment of a probe command for the backend.
+func commandEnv(rawURL string) []string {
+	env := append(os.Environ(), "FLO_LB_BACKEND_URL="+rawURL)
+	if addr, err := tcpProbeAddress(rawURL, 0); err == nil {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			env = append(env, "FLO_LB_BACKEND_HOST="+host, "FLO_LB_BACKEND_PORT="+port)
+		}
+	}
+	return env
+}
+
+// runCommand runs the probe command for the backend, returning
+// if it succeeded and what it wrote.
+func runCommand(ctx context.Context, rawURL string, probe *pb.Command) (bool, string, error) {
+	timeout := defaultCommandTimeout
+	if probe.GetTimeout() != nil {
+		timeout = probe.GetTimeout().AsDuration()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output := &cappedBuffer{max: maxCommandOutput}
+	cmd := exec.CommandContext(ctx, probe.GetPath(), probe.GetArgs()...)
+	cmd.Env = commandEnv(rawURL)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	killGroupOnCancel(cmd)
+	// Processes started by the command can keep its output open after it
+	// is killed, so stop waiting for them shortly after.
+	cmd.WaitDelay = commandWaitDelay
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if ctx.Err() != nil {
+		return false, output.buf.String(), ctx.Err()
+	} else if errors.As(err, &exitErr) {
+		return false, output.buf.String(), nil
+	} else if err != nil {
+		return false, output.buf.String(), err
+	}
+	return true, output.buf.String(), nil
+}
+
+// commandAlive checks if the probe command succeeds for the backend.
+func commandAlive(ctx context.Context, be *algos.Backend, probe *pb.Command) bool {
+	ok, output, err := runCommand(ctx, be.URL(), probe)
+	if err != nil {
+		log.Printf("Error running the probe command of %v: %v, output: %q", be.URL(), err, output)
+		return false
+	} else if !ok {
+		log.Printf("Probe command of %v failed, output: %q", be.URL(), output)
+		return false
+	}
+	return true
+}
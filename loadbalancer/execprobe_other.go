@@ -0,0 +1,9 @@
+//go:build !unix
+
+package loadbalancer
+
+import "os/exec"
+
+// killGroupOnCancel leaves the command as is, only the command itself is
+// killed when it is canceled.
+func killGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,115 @@
+package loadbalancer
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+func shellCommand(t *testing.T, script string) *pb.Command {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the probe commands")
+	}
+	return &pb.Command{Path: proto.String(sh), Args: []string{"-c", script}}
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		timeout    time.Duration
+		want       bool
+		wantErr    bool
+		wantOutput string
+	}{
+		{
+			name:       "Backend in the environment",
+			script:     `echo "$FLO_LB_BACKEND_HOST $FLO_LB_BACKEND_PORT $FLO_LB_BACKEND_URL"`,
+			want:       true,
+			wantOutput: "localhost 6379 http://localhost:6379\n",
+		},
+		{
+			name:       "Non zero exit code",
+			script:     "echo not ready; exit 3",
+			want:       false,
+			wantOutput: "not ready\n",
+		},
+		{
+			name:    "Timeout",
+			script:  "exec sleep 5",
+			timeout: 50 * time.Millisecond,
+			want:    false,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command := shellCommand(t, test.script)
+			if test.timeout > 0 {
+				command.Timeout = durationpb.New(test.timeout)
+			}
+			got, output, err := runCommand(context.Background(), "http://localhost:6379", command)
+			if got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("want error %v, got %v", test.wantErr, err)
+			}
+			if output != test.wantOutput {
+				t.Errorf("want output %q, got %q", test.wantOutput, output)
+			}
+		})
+	}
+}
+
+func TestRunCommandKillsChildren(t *testing.T) {
+	// The shell forks sleep, which keeps the output open.
+	command := shellCommand(t, "sleep 3; true")
+	command.Timeout = durationpb.New(50 * time.Millisecond)
+	start := time.Now()
+	if ok, _, err := runCommand(context.Background(), "http://localhost", command); ok || err == nil {
+		t.Errorf("want a failure with an error, got %v and %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want runCommand to return after the timeout, took %v", elapsed)
+	}
+}
+
+func TestRunCommandCapsOutput(t *testing.T) {
+	command := shellCommand(t, "i=0; while [ $i -lt 1000 ]; do echo 0123456789; i=$((i+1)); done")
+	_, output, err := runCommand(context.Background(), "http://localhost", command)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(output) != maxCommandOutput || !strings.HasPrefix(output, "0123456789\n") {
+		t.Errorf("want the first %v bytes of the output, got %v bytes", maxCommandOutput, len(output))
+	}
+}
+
+func TestRunCommandMissingBinary(t *testing.T) {
+	command := &pb.Command{Path: proto.String("/does/not/exist")}
+	if ok, _, err := runCommand(context.Background(), "http://localhost", command); ok || err == nil {
+		t.Errorf("want a failure with an error, got %v and %v", ok, err)
+	}
+}
+
+func TestCommandProbeNeedsPath(t *testing.T) {
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{},
+		HealthCheck: &pb.HealthCheck{
+			Probe: &pb.HealthProbe{Type: &pb.HealthProbe_Command{Command: &pb.Command{}}},
+		},
+	}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("New() want error, got nil")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package loadbalancer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killGroupOnCancel runs the command in its own process group and kills
+// the whole group when the command is canceled, so the processes it
+// started do not outlive it.
+func killGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
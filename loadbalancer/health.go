@@ -2,13 +2,20 @@ package loadbalancer
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
 )
 
+// maxLoadReportSize limits how much of a health check body is read
+// looking for a load report.
+const maxLoadReportSize = 1024
+
 type deregistrar interface {
 	Deregister(url string) error
 }
@@ -39,15 +46,35 @@ func (hc *deadCounter) resetCounter(rawURL string) {
 	hc.failedChecks[rawURL] = 0
 }
 
-// pingBackend checks if the backend is alive.
-func (s *Server) alive(ctx context.Context, be *algos.Backend) bool {
+// alive checks if the backend is alive with the probe of the pool,
+// counting the failed checks.
+func (p *backendPool) alive(ctx context.Context, be *algos.Backend) bool {
+	var alive bool
+	probe := p.healthCheck.GetProbe()
+	switch {
+	case probe.GetTcp() != nil:
+		alive = tcpAlive(ctx, be, probe.GetTcp())
+	case probe.GetCommand() != nil:
+		alive = commandAlive(ctx, be, probe.GetCommand())
+	default:
+		alive = p.httpAlive(ctx, be)
+	}
+	if alive {
+		p.deadCounter.resetCounter(be.URL())
+	} else {
+		p.deadCounter.incFailed(be.URL())
+	}
+	return alive
+}
+
+// httpAlive checks if the backend answers its health path with a 200.
+func (p *backendPool) httpAlive(ctx context.Context, be *algos.Backend) bool {
 	rawURL := be.URL()
-	healthPath := rawURL + s.cfg.GetHealthCheck().GetProbe().GetHttpGet().GetPath()
+	healthPath := rawURL + p.healthCheck.GetProbe().GetHttpGet().GetPath()
 	// TODO Healthcheck: Consider adding extra args to the request.
 	req, err := http.NewRequest("GET", healthPath, nil)
 	if err != nil {
 		log.Printf("Error creating request to %v: %v\n, will consider the backend down", req, err)
-		s.deadCounter.incFailed(rawURL)
 		return false
 	}
 	client := http.Client{
@@ -57,20 +84,32 @@ func (s *Server) alive(ctx context.Context, be *algos.Backend) bool {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("%v is unreachable, error: %v", healthPath, err.Error())
-		s.deadCounter.incFailed(rawURL)
 		return false
-	} else if resp.StatusCode != http.StatusOK {
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
 		log.Printf("Received non-OK status: %v", resp.StatusCode)
-		s.deadCounter.incFailed(rawURL)
 		return false
 	}
-	s.deadCounter.resetCounter(rawURL)
+	p.readLoadReport(be, resp.Body)
 	return true
 }
 
-func (s *Server) checkHealth(ctx context.Context, be *algos.Backend) {
+// readLoadReport records the load of a backend that reports it
+// in the body of its health check responses.
+func (p *backendPool) readLoadReport(be *algos.Backend, body io.Reader) {
+	rawLoad, err := ioutil.ReadAll(io.LimitReader(body, maxLoadReportSize))
+	if err != nil {
+		return
+	}
+	if report, err := algos.ParseLoadReport(string(rawLoad)); err == nil {
+		be.ReportLoad(report)
+	}
+}
+
+func (p *backendPool) checkHealth(ctx context.Context, be *algos.Backend) {
 	msg := "alive"
-	alive := s.alive(ctx, be)
+	alive := p.alive(ctx, be)
 	be.SetAlive(alive)
 	if !alive {
 		msg = "dead"
@@ -80,29 +119,47 @@ func (s *Server) checkHealth(ctx context.Context, be *algos.Backend) {
 	log.Printf("%v checked %v by healthcheck", be.URL(), msg)
 }
 
+// StartHealthChecks starts checking the backends of every pool with a
+// health check or an outlier detection, it returns once all the checks
+// are running.
 func (s *Server) StartHealthChecks(ctx context.Context) {
-	if s.cfg.GetHealthCheck().GetProbe().GetCommand() != nil {
-		log.Fatalf("Custom command health probes are not yet supported!")
+	var wg sync.WaitGroup
+	for _, pool := range s.pools {
+		if pool.outliers != nil {
+			log.Printf("Starting the outlier detection of pool %v", pool.name)
+			pool.lbAlgo.RegisterCheck(ctx, algos.NewPoolChecker(pool.outliers.analyze, pool.outliers.interval))
+		}
+		if pool.healthCheck == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(pool *backendPool) {
+			defer wg.Done()
+			pool.startHealthChecks(ctx)
+		}(pool)
 	}
+	wg.Wait()
+}
 
-	if s.cfg.GetHealthCheck().GetDisconnectThreshold() > 0 {
-		s.deadCounter = &deadCounter{
+func (p *backendPool) startHealthChecks(ctx context.Context) {
+	if p.healthCheck.GetDisconnectThreshold() > 0 {
+		p.deadCounter = &deadCounter{
 			failedChecks: make(map[string]int32),
-			maxFails:     s.cfg.GetHealthCheck().GetDisconnectThreshold(),
-			deregistrar:  s.lbAlgo,
+			maxFails:     p.healthCheck.GetDisconnectThreshold(),
+			deregistrar:  p.lbAlgo,
 		}
 	}
 
-	initDelay := s.cfg.GetHealthCheck().GetInitialDelay().AsDuration()
-	log.Printf("Waiting an initial delay of %v for backends to wake up.", initDelay)
+	initDelay := p.healthCheck.GetInitialDelay().AsDuration()
+	log.Printf("Waiting an initial delay of %v for backends of pool %v to wake up.", initDelay, p.name)
 	time.Sleep(initDelay)
 
-	log.Printf("Starting to check the health of backends")
-	period := s.cfg.GetHealthCheck().GetPeriod().AsDuration()
-	s.lbAlgo.RegisterCheck(
+	log.Printf("Starting to check the health of backends of pool %v", p.name)
+	period := p.healthCheck.GetPeriod().AsDuration()
+	p.lbAlgo.RegisterCheck(
 		ctx,
 		algos.NewChecker(
-			s.checkHealth, period,
+			p.checkHealth, period,
 		),
 	)
 }
@@ -3,14 +3,12 @@ package loadbalancer
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"sync"
 
 	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
 	pb "github.com/FlorinBalint/flo_lb/proto"
-	"google.golang.org/protobuf/proto"
 )
 
 type lbAlgorithm interface {
@@ -20,15 +18,41 @@ type lbAlgorithm interface {
 	RegisterCheck(ctx context.Context, chk *algos.Checker)
 }
 
+// weightedRegistrar is implemented by the algorithms that honour
+// the weight of dynamically registered backends.
+type weightedRegistrar interface {
+	RegisterWeighted(rawURL string, weight int32) error
+}
+
 var _ lbAlgorithm = (*algos.RoundRobin)(nil)
 var _ lbAlgorithm = (*algos.LeastConnections)(nil)
+var _ lbAlgorithm = (*algos.LowestLatency)(nil)
+var _ lbAlgorithm = (*algos.ResourceBased)(nil)
+var _ lbAlgorithm = (*algos.ConsistentHash)(nil)
+var _ lbAlgorithm = (*algos.Maglev)(nil)
+var _ lbAlgorithm = (*algos.P2C)(nil)
+var _ lbAlgorithm = (*algos.ModuloHash)(nil)
+var _ weightedRegistrar = (*algos.RoundRobin)(nil)
+var _ weightedRegistrar = (*stickySessions)(nil)
+var _ backendLookup = (*stickySessions)(nil)
 
 type Server struct {
+	// The pool of the top level backend config, which gets the requests not
+	// matched by any route. It is nil if only named pools are configured.
+	defaultPool *backendPool
 	cfg         *pb.Config
 	server      *http.Server
-	lbAlgo      lbAlgorithm
-	deadCounter *deadCounter
-	mu          sync.RWMutex
+	pools       map[string]*backendPool
+	router      *router
+	// Routers of the virtual hosts, by domain.
+	vhosts *hostMatcher[*router]
+	// Traffic splits of the named routes, by route name.
+	splits map[string]*trafficSplit
+	// maintenance is 1 while in maintenance mode, accessed atomically.
+	maintenance     int32
+	maintenancePage *directResponse
+	errorPages      *errorPages
+	mu              sync.RWMutex
 }
 
 func New(cfg *pb.Config) (*Server, error) {
@@ -39,101 +63,126 @@ func New(cfg *pb.Config) (*Server, error) {
 			Addr:    fmt.Sprintf(":%v", cfg.GetPort()),
 			Handler: mux,
 		},
+		pools:  make(map[string]*backendPool),
+		vhosts: newHostMatcher[*router](),
+		splits: make(map[string]*trafficSplit),
 	}
 
-	var err error
-	switch cfg.GetAlgorithm() {
-	case pb.BalancingAlgorithm_LeastConnections:
-		lb.lbAlgo, err = algos.NewLeastConnections(cfg.GetBackend())
-	default:
-		lb.lbAlgo, err = algos.NewRoundRobin(cfg.GetBackend())
+	if cfg.GetBackend() != nil || len(cfg.GetPools()) == 0 {
+		pool, err := newBackendPool(defaultPool, cfg.GetAlgorithm(), cfg.GetBackend(), cfg.GetHealthCheck())
+		if err != nil {
+			return nil, err
+		}
+		lb.defaultPool = pool
+		lb.pools[defaultPool] = pool
 	}
-	if err != nil {
-		return nil, err
+	for _, poolCfg := range cfg.GetPools() {
+		if len(poolCfg.GetName()) == 0 {
+			return nil, fmt.Errorf("backend pools must have a name")
+		} else if _, present := lb.pools[poolCfg.GetName()]; present {
+			return nil, fmt.Errorf("duplicate backend pool %v", poolCfg.GetName())
+		}
+		healthCheck := poolCfg.GetHealthCheck()
+		if healthCheck == nil {
+			healthCheck = cfg.GetHealthCheck()
+		}
+		pool, err := newBackendPool(poolCfg.GetName(), poolCfg.GetAlgorithm(), poolCfg.GetBackend(), healthCheck)
+		if err != nil {
+			return nil, err
+		}
+		lb.pools[poolCfg.GetName()] = pool
 	}
 
-	mux.Handle("/", http.HandlerFunc(lb.ServeHTTP))
-	mux.Handle("/healthz", http.HandlerFunc(lb.Health))
-	if cfg.GetBackend().GetDynamic() != nil {
-		mux.Handle(cfg.Backend.GetDynamic().GetRegisterPath(), http.HandlerFunc(lb.RegisterNew))
-		mux.Handle(cfg.Backend.GetDynamic().GetDeregisterPath(), http.HandlerFunc(lb.Deregister))
+	maintenanceCfg := cfg.GetMaintenance().GetResponse()
+	if maintenanceCfg == nil {
+		maintenanceCfg = defaultMaintenance
+	}
+	var err error
+	if lb.maintenancePage, err = newDirectResponse(maintenanceCfg); err != nil {
+		return nil, err
+	}
+	lb.setMaintenance(cfg.GetMaintenance().GetEnabled())
+	if lb.errorPages, err = newErrorPages(cfg.GetErrorPages()); err != nil {
+		return nil, err
 	}
 
-	return lb, nil
-}
-
-func (s *Server) RegisterNew(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received register request")
-	body, err := ioutil.ReadAll(r.Body)
+	lb.router, err = newRouter(cfg.GetRoutes(), lb.pools, defaultPool)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Error reading request"))
-		return
+		return nil, err
 	}
-	regReq := &pb.RegisterRequest{}
-	if err := proto.Unmarshal(body, regReq); err != nil {
-		log.Printf("Failed to parse register request: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Error reading request"))
-		return
-	} else if len(regReq.GetHost()) == 0 {
-		log.Printf("Received register request without host: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Request must have host set"))
-		return
+	routeNames := make(map[string]bool)
+	if err := lb.addSplits(lb.router, routeNames); err != nil {
+		return nil, err
 	}
-
-	var rawUrl string
-	if regReq.Port != nil {
-		rawUrl = fmt.Sprintf("http://%v:%v", regReq.GetHost(), regReq.GetPort())
-	} else {
-		rawUrl = fmt.Sprintf("http://%v", regReq.GetHost())
+	for _, vhost := range cfg.GetVirtualHosts() {
+		if len(vhost.GetDomains()) == 0 {
+			return nil, fmt.Errorf("virtual hosts must have at least a domain")
+		}
+		fallback := defaultPool
+		if vhost.DefaultPool != nil {
+			fallback = vhost.GetDefaultPool()
+			if _, present := lb.pools[fallback]; !present {
+				return nil, fmt.Errorf("virtual host %v uses unknown default pool %q", vhost.GetDomains(), fallback)
+			}
+		}
+		rtr, err := newRouter(vhost.GetRoutes(), lb.pools, fallback)
+		if err != nil {
+			return nil, err
+		} else if err := lb.addSplits(rtr, routeNames); err != nil {
+			return nil, err
+		}
+		for _, domain := range vhost.GetDomains() {
+			if err := lb.vhosts.add(domain, rtr); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	if err := s.lbAlgo.Register(rawUrl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Error handling register"))
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Registered"))
+	mux.Handle("/", http.HandlerFunc(lb.ServeHTTP))
+	mux.Handle("/healthz", http.HandlerFunc(lb.Health))
+	if len(cfg.GetSplitPath()) > 0 {
+		mux.Handle(cfg.GetSplitPath(), http.HandlerFunc(lb.SetSplit))
 	}
-}
-
-func (s *Server) Deregister(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received deregister request")
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Error reading request"))
-		return
+	if len(cfg.GetMetricsPath()) > 0 {
+		mux.Handle(cfg.GetMetricsPath(), http.HandlerFunc(lb.Metrics))
 	}
-	regReq := &pb.DeregisterRequest{}
-	if err := proto.Unmarshal(body, regReq); err != nil {
-		log.Printf("Failed to parse unregister request: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Error reading request"))
-		return
-	} else if len(regReq.GetHost()) == 0 {
-		log.Printf("Received unregister request without host: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Request must have host set"))
-		return
+	if len(cfg.GetMaintenance().GetTogglePath()) > 0 {
+		mux.Handle(cfg.GetMaintenance().GetTogglePath(), http.HandlerFunc(lb.ToggleMaintenance))
 	}
-
-	var rawUrl string
-	if regReq.Port != nil {
-		rawUrl = fmt.Sprintf("http://%v:%v", regReq.GetHost(), regReq.GetPort())
-	} else {
-		rawUrl = fmt.Sprintf("http://%v", regReq.GetHost())
+	membershipPaths := make(map[string]string)
+	for _, pool := range lb.pools {
+		dynamic := pool.beCfg.GetDynamic()
+		if dynamic == nil {
+			continue
+		}
+		for _, path := range []string{dynamic.GetRegisterPath(), dynamic.GetDeregisterPath()} {
+			if other, present := membershipPaths[path]; present {
+				return nil, fmt.Errorf("pools %v and %v use the same membership path %v", other, pool.name, path)
+			}
+			membershipPaths[path] = pool.name
+		}
+		mux.Handle(dynamic.GetRegisterPath(), http.HandlerFunc(pool.RegisterNew))
+		mux.Handle(dynamic.GetDeregisterPath(), http.HandlerFunc(pool.Deregister))
 	}
 
-	if err := s.lbAlgo.Deregister(rawUrl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Error handling deregister"))
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Registered"))
+	return lb, nil
+}
+
+// addSplits indexes the traffic splits of the routes of rtr by route name,
+// checking that route names are unique.
+func (s *Server) addSplits(rtr *router, routeNames map[string]bool) error {
+	for _, rt := range rtr.routes {
+		if len(rt.name) == 0 {
+			continue
+		} else if routeNames[rt.name] {
+			return fmt.Errorf("duplicate route name %v", rt.name)
+		}
+		routeNames[rt.name] = true
+		if rt.split != nil {
+			s.splits[rt.name] = rt.split
+		}
 	}
+	return nil
 }
 
 func (s *Server) Health(w http.ResponseWriter, r *http.Request) {
@@ -142,10 +191,26 @@ func (s *Server) Health(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("I am alive"))
 }
 
-// ServeHTTP is Round Robin handler for loadbalancing
+// ServeHTTP balances the request over the pool of its route,
+// within the virtual host of the request if any, unless the
+// load balancer is in maintenance.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Received request for %v\n", r.URL)
-	s.lbAlgo.Handler(r).ServeHTTP(w, r)
+	ensureRequestID(r)
+	if s.inMaintenance() {
+		s.maintenancePage.ServeHTTP(w, r)
+		return
+	}
+	rtr, ok := s.vhosts.match(requestHost(r))
+	if !ok {
+		rtr = s.router
+	}
+	rt := rtr.match(r)
+	if rt == nil {
+		errorWriter(s.errorPages)(w, r, http.StatusNotFound, "404 page not found\n")
+		return
+	}
+	rt.ServeHTTP(w, algos.WithErrorWriter(r, errorWriter(rt.errorPages, s.errorPages)))
 }
 
 func (s *Server) ListenAndServe(ctx context.Context) error {
@@ -153,18 +218,18 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 	defer cancel()
 
 	// TODO(#1): Validate that we are using https protocol
-	if s.cfg.GetCert() != nil {
+	if s.hasCerts() {
 		err := s.SetupTLS(ctx)
 		if err != nil {
 			return fmt.Errorf("error loading certs %v", err)
 		}
 	}
 
-	if s.cfg.GetHealthCheck() != nil {
-		go s.StartHealthChecks(lbContext)
-	}
+	go s.StartHealthChecks(lbContext)
 
-	log.Printf("Starting load balancer with backends %v\n", s.cfg.GetBackend().GetStatic().GetUrls())
+	for _, pool := range s.pools {
+		log.Printf("Starting pool %v with backends %v\n", pool.name, pool.beCfg.GetStatic().GetUrls())
+	}
 	log.Printf("%v balancer will start listening on port %v\n", s.cfg.GetName(), s.cfg.GetPort())
 	if s.cfg.GetProtocol() == pb.Protocol_HTTPS {
 		return s.server.ListenAndServeTLS("", "")
@@ -164,7 +164,7 @@ func TestLBHandler(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			for _, be := range test.backends {
-				go be.startListen(t)
+				be.startListen(t)
 				defer be.stop(t)
 			}
 
@@ -212,8 +212,9 @@ func TestLBHandler(t *testing.T) {
 }
 
 type fakeLbAlgo struct {
-	registerUrl   string
-	deregisterUrl string
+	registerUrl    string
+	registerWeight int32
+	deregisterUrl  string
 }
 
 func (fakeAlgo *fakeLbAlgo) Register(rawURL string) error {
@@ -221,6 +222,12 @@ func (fakeAlgo *fakeLbAlgo) Register(rawURL string) error {
 	return nil
 }
 
+func (fakeAlgo *fakeLbAlgo) RegisterWeighted(rawURL string, weight int32) error {
+	fakeAlgo.registerUrl = rawURL
+	fakeAlgo.registerWeight = weight
+	return nil
+}
+
 func (fakeAlgo *fakeLbAlgo) Deregister(rawURL string) error {
 	fakeAlgo.deregisterUrl = rawURL
 	return nil
@@ -256,18 +263,19 @@ func fakeBalancer(t *testing.T) (*Server, error) {
 	if srv, err := New(cfg); err != nil {
 		return nil, err
 	} else {
-		srv.lbAlgo = &fakeLbAlgo{}
+		srv.defaultPool.lbAlgo = &fakeLbAlgo{}
 		return srv, nil
 	}
 }
 
 func registerRequest(t *testing.T,
 	lbAddr string,
-	host string, port *int32) (*http.Request, error) {
+	host string, port *int32, weight *int32) (*http.Request, error) {
 	t.Helper()
 	bodyReq := &pb.RegisterRequest{
-		Host: proto.String(host),
-		Port: port,
+		Host:   proto.String(host),
+		Port:   port,
+		Weight: weight,
 	}
 
 	if body, err := proto.Marshal(bodyReq); err != nil {
@@ -303,7 +311,9 @@ func TestRegister(t *testing.T) {
 		name             string
 		host             string
 		port             *int32
+		weight           *int32
 		expectedRegister string
+		expectedWeight   int32
 	}{
 		{
 			name:             "Register correct host with port",
@@ -316,6 +326,14 @@ func TestRegister(t *testing.T) {
 			host:             "hostA",
 			expectedRegister: "http://hostA",
 		},
+		{
+			name:             "Register with weight",
+			host:             "hostA",
+			port:             proto.Int32(8081),
+			weight:           proto.Int32(5),
+			expectedRegister: "http://hostA:8081",
+			expectedWeight:   5,
+		},
 	}
 
 	for _, test := range tests {
@@ -333,7 +351,7 @@ func TestRegister(t *testing.T) {
 			defer frontend.Close()
 			req, err := registerRequest(
 				t, frontend.URL,
-				test.host, test.port,
+				test.host, test.port, test.weight,
 			)
 			if err != nil {
 				t.Errorf("error creating register request: %v", err)
@@ -345,8 +363,11 @@ func TestRegister(t *testing.T) {
 			} else if resp.StatusCode != http.StatusOK {
 				t.Errorf("unexpected error code, want %v, got %v", resp.StatusCode, http.StatusOK)
 			}
-			if test.expectedRegister != (lb.lbAlgo).(*fakeLbAlgo).registerUrl {
-				t.Errorf("unexpected register, want %v, got %v", test.expectedRegister, (lb.lbAlgo).(*fakeLbAlgo).registerUrl)
+			if test.expectedRegister != lb.defaultPool.lbAlgo.(*fakeLbAlgo).registerUrl {
+				t.Errorf("unexpected register, want %v, got %v", test.expectedRegister, lb.defaultPool.lbAlgo.(*fakeLbAlgo).registerUrl)
+			}
+			if test.expectedWeight != lb.defaultPool.lbAlgo.(*fakeLbAlgo).registerWeight {
+				t.Errorf("unexpected weight, want %v, got %v", test.expectedWeight, lb.defaultPool.lbAlgo.(*fakeLbAlgo).registerWeight)
 			}
 		})
 	}
@@ -397,8 +418,8 @@ func TestDeregister(t *testing.T) {
 			} else if resp.StatusCode != http.StatusOK {
 				t.Errorf("unexpected error code, want %v, got %v", resp.StatusCode, http.StatusOK)
 			}
-			if test.expectedDeregister != (lb.lbAlgo).(*fakeLbAlgo).deregisterUrl {
-				t.Errorf("unexpected register, want %v, got %v", test.expectedDeregister, (lb.lbAlgo).(*fakeLbAlgo).deregisterUrl)
+			if test.expectedDeregister != lb.defaultPool.lbAlgo.(*fakeLbAlgo).deregisterUrl {
+				t.Errorf("unexpected register, want %v, got %v", test.expectedDeregister, lb.defaultPool.lbAlgo.(*fakeLbAlgo).deregisterUrl)
 			}
 		})
 	}
@@ -468,7 +489,7 @@ func newTestLBWithFakeAlgo(t *testing.T, testBe *testBackend) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	srv.lbAlgo = &fakeLBAlgo{}
+	srv.defaultPool.lbAlgo = &fakeLBAlgo{}
 	return srv, nil
 }
 
@@ -551,7 +572,7 @@ func TestDeadCounter(t *testing.T) {
 	t.Parallel()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			go test.backend.startListen(t)
+			test.backend.startListen(t)
 			defer test.backend.stop(t)
 
 			lb, err := newTestLBWithFakeAlgo(t, test.backend)
@@ -559,10 +580,10 @@ func TestDeadCounter(t *testing.T) {
 			if err != nil {
 				t.Errorf("error creating LB: %v", err)
 			}
-			fakeAlgo := lb.lbAlgo.(*fakeLBAlgo)
+			fakeAlgo := lb.defaultPool.lbAlgo.(*fakeLBAlgo)
 			if test.initialCounter != nil {
-				lb.deadCounter = test.initialCounter
-				lb.deadCounter.deregistrar = fakeAlgo
+				lb.defaultPool.deadCounter = test.initialCounter
+				lb.defaultPool.deadCounter.deregistrar = fakeAlgo
 			}
 
 			rawURL := test.backend.server.URL
@@ -572,7 +593,7 @@ func TestDeadCounter(t *testing.T) {
 			}
 
 			for i := 0; i < test.noRequests; i++ {
-				lb.alive(context.Background(), be)
+				lb.defaultPool.alive(context.Background(), be)
 			}
 
 			if test.wantDeregister {
@@ -585,11 +606,59 @@ func TestDeadCounter(t *testing.T) {
 				t.Errorf("want no deregistration, but got some: %v", fakeAlgo.deregistrations)
 			}
 			if test.initialCounter != nil {
-				gotCount := lb.deadCounter.failedChecks[rawURL]
+				gotCount := lb.defaultPool.deadCounter.failedChecks[rawURL]
 				if gotCount != test.wantCount {
-					t.Errorf("want count %v, got %v", test.wantCount, lb.deadCounter.failedChecks[rawURL])
+					t.Errorf("want count %v, got %v", test.wantCount, lb.defaultPool.deadCounter.failedChecks[rawURL])
 				}
 			}
 		})
 	}
 }
+
+func TestServerWithoutDefaultPool(t *testing.T) {
+	backend := alwaysAliveBackend()
+	backend.startListen(t)
+	defer backend.stop(t)
+	cfg := &pb.Config{
+		Name: proto.String("Test LB"),
+		Pools: []*pb.BackendPool{
+			{
+				Name: proto.String("api"),
+				Backend: &pb.BackendConfig{
+					Type: &pb.BackendConfig_Static{
+						Static: &pb.StaticBackends{Urls: []string{backend.server.URL}},
+					},
+				},
+			},
+		},
+		Routes: []*pb.Route{
+			{PathPrefix: proto.String("/api"), Pool: proto.String("api")},
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	defer lb.Close()
+	if lb.defaultPool != nil {
+		t.Errorf("want no default pool, got %v", lb.defaultPool.name)
+	}
+	lb.pools["api"].lbAlgo.(backendLookup).Lookup(backend.server.URL).SetAlive(true)
+
+	tests := []struct {
+		path     string
+		wantCode int
+	}{
+		{path: "/api/items", wantCode: http.StatusOK},
+		{path: "/other", wantCode: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			lb.ServeHTTP(resp, httptest.NewRequest("GET", test.path, nil))
+			if resp.Code != test.wantCode {
+				t.Errorf("want status %v, got %v", test.wantCode, resp.Code)
+			}
+		})
+	}
+}
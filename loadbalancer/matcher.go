@@ -0,0 +1,68 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// valueMatcher matches the values of a header or query parameter.
+type valueMatcher struct {
+	name string
+	// Exactly one of exact and regex is set, or neither when only the
+	// presence of the value matters.
+	exact   *string
+	regex   *regexp.Regexp
+	present bool
+}
+
+func newValueMatcher(matcherCfg *pb.ValueMatcher) (*valueMatcher, error) {
+	if len(matcherCfg.GetName()) == 0 {
+		return nil, fmt.Errorf("header and query parameter matchers must have a name")
+	}
+	matcher := &valueMatcher{
+		name:    matcherCfg.GetName(),
+		present: true,
+	}
+	switch match := matcherCfg.GetMatch().(type) {
+	case *pb.ValueMatcher_Exact:
+		matcher.exact = &match.Exact
+	case *pb.ValueMatcher_Regex:
+		regex, err := regexp.Compile("^(?:" + match.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex for %v: %v", matcherCfg.GetName(), err)
+		}
+		matcher.regex = regex
+	case *pb.ValueMatcher_Present:
+		matcher.present = match.Present
+	}
+	return matcher, nil
+}
+
+// matches reports if any of values matches, or if there are no values
+// for a matcher that wants them absent.
+func (m *valueMatcher) matches(values []string) bool {
+	if !m.present {
+		return len(values) == 0
+	}
+	for _, value := range values {
+		if m.exact != nil && value != *m.exact {
+			continue
+		} else if m.regex != nil && !m.regex.MatchString(value) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (m *valueMatcher) matchesHeader(header http.Header) bool {
+	return m.matches(header.Values(m.name))
+}
+
+func (m *valueMatcher) matchesQuery(query url.Values) bool {
+	return m.matches(query[m.name])
+}
@@ -0,0 +1,101 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestValueMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher *pb.ValueMatcher
+		values  []string
+		want    bool
+	}{
+		{
+			name:    "Present by default",
+			matcher: &pb.ValueMatcher{Name: proto.String("X-Canary")},
+			values:  []string{""},
+			want:    true,
+		},
+		{
+			name:    "Missing value is not present",
+			matcher: &pb.ValueMatcher{Name: proto.String("X-Canary")},
+		},
+		{
+			name: "Absent",
+			matcher: &pb.ValueMatcher{
+				Name:  proto.String("X-Canary"),
+				Match: &pb.ValueMatcher_Present{Present: false},
+			},
+			want: true,
+		},
+		{
+			name: "Absent but present",
+			matcher: &pb.ValueMatcher{
+				Name:  proto.String("X-Canary"),
+				Match: &pb.ValueMatcher_Present{Present: false},
+			},
+			values: []string{"true"},
+		},
+		{
+			name: "Exact match on any value",
+			matcher: &pb.ValueMatcher{
+				Name:  proto.String("X-Canary"),
+				Match: &pb.ValueMatcher_Exact{Exact: "true"},
+			},
+			values: []string{"false", "true"},
+			want:   true,
+		},
+		{
+			name: "Exact mismatch",
+			matcher: &pb.ValueMatcher{
+				Name:  proto.String("X-Canary"),
+				Match: &pb.ValueMatcher_Exact{Exact: "true"},
+			},
+			values: []string{"True"},
+		},
+		{
+			name: "Regex match",
+			matcher: &pb.ValueMatcher{
+				Name:  proto.String("version"),
+				Match: &pb.ValueMatcher_Regex{Regex: "v[0-9]+"},
+			},
+			values: []string{"v42"},
+			want:   true,
+		},
+		{
+			name: "Regex must match the whole value",
+			matcher: &pb.ValueMatcher{
+				Name:  proto.String("version"),
+				Match: &pb.ValueMatcher_Regex{Regex: "v[0-9]+"},
+			},
+			values: []string{"v42-beta"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := newValueMatcher(test.matcher)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got := matcher.matches(test.values); got != test.want {
+				t.Errorf("matches(%q) want %v, got %v", test.values, test.want, got)
+			}
+		})
+	}
+}
+
+func TestValueMatcherErrors(t *testing.T) {
+	for _, matcherCfg := range []*pb.ValueMatcher{
+		{Match: &pb.ValueMatcher_Exact{Exact: "true"}},
+		{Name: proto.String("version"), Match: &pb.ValueMatcher_Regex{Regex: "v[0-9"}},
+	} {
+		if _, err := newValueMatcher(matcherCfg); err == nil {
+			t.Errorf("newValueMatcher(%v) want error, got nil", matcherCfg)
+		}
+	}
+}
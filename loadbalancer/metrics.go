@@ -0,0 +1,78 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// metric is a metric of the pools, in the Prometheus text format.
+type metric struct {
+	name  string
+	help  string
+	typ   string
+	value func(stats budgetStats) float64
+}
+
+var budgetMetrics = []metric{
+	{
+		name:  "flo_lb_retry_budget_requests",
+		help:  "Requests of the pool in the retry budget window.",
+		typ:   "gauge",
+		value: func(stats budgetStats) float64 { return float64(stats.requests) },
+	},
+	{
+		name:  "flo_lb_retry_budget_retries",
+		help:  "Retries of the pool in the retry budget window.",
+		typ:   "gauge",
+		value: func(stats budgetStats) float64 { return float64(stats.retries) },
+	},
+	{
+		name:  "flo_lb_retry_budget_remaining",
+		help:  "Retries the retry budget of the pool still allows.",
+		typ:   "gauge",
+		value: func(stats budgetStats) float64 { return stats.remaining },
+	},
+	{
+		name:  "flo_lb_retries_total",
+		help:  "Retries sent to the pool.",
+		typ:   "counter",
+		value: func(stats budgetStats) float64 { return float64(stats.retried) },
+	},
+	{
+		name:  "flo_lb_retries_rejected_total",
+		help:  "Retries denied by the retry budget of the pool.",
+		typ:   "counter",
+		value: func(stats budgetStats) float64 { return float64(stats.rejected) },
+	},
+}
+
+// writeMetrics writes the metrics of the pools retrying their requests.
+func (s *Server) writeMetrics(w io.Writer) {
+	var names []string
+	stats := make(map[string]budgetStats)
+	for name, pool := range s.pools {
+		if pool.retry != nil {
+			names = append(names, name)
+			stats[name] = pool.retry.budget.stats()
+		}
+	}
+	sort.Strings(names)
+	for _, m := range budgetMetrics {
+		if len(names) == 0 {
+			break
+		}
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.typ)
+		for _, name := range names {
+			fmt.Fprintf(w, "%v{pool=%q} %v\n", m.name, name, m.value(stats[name]))
+		}
+	}
+}
+
+// Metrics serves the metrics of the load balancer in the Prometheus text format.
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	s.writeMetrics(w)
+}
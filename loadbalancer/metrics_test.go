@@ -0,0 +1,46 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := &pb.Config{
+		MetricsPath: proto.String("/metrics"),
+		Pools: []*pb.BackendPool{
+			{Name: proto.String("retried"), Backend: &pb.BackendConfig{Retry: &pb.RetryPolicy{}}},
+			{Name: proto.String("plain"), Backend: &pb.BackendConfig{}},
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	lb.pools["retried"].retry.budget.request()
+
+	resp := httptest.NewRecorder()
+	lb.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("want status %v, got %v", http.StatusOK, resp.Code)
+	}
+	for _, want := range []string{
+		"# TYPE flo_lb_retry_budget_requests gauge\n",
+		"flo_lb_retry_budget_requests{pool=\"retried\"} 1\n",
+		"flo_lb_retry_budget_remaining{pool=\"retried\"} 100.2\n",
+		"flo_lb_retries_rejected_total{pool=\"retried\"} 0\n",
+	} {
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Errorf("want metrics containing %q, got %v", want, resp.Body.String())
+		}
+	}
+	if strings.Contains(resp.Body.String(), "plain") {
+		t.Errorf("want no metrics for pools without retries, got %v", resp.Body.String())
+	}
+}
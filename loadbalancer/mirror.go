@@ -0,0 +1,189 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const (
+	// mirrorHeader marks the copies of the mirrored requests.
+	mirrorHeader         = "X-Flo-Lb-Mirror"
+	defaultMirrorMaxBody = 1 << 20
+	defaultMirrorTimeout = 10 * time.Second
+	// maxMirrorsInFlight bounds the copies a mirror sends at once, extra
+	// copies are dropped rather than piling up behind slow shadow backends.
+	maxMirrorsInFlight = 100
+)
+
+// mirror copies requests to a shadow pool and discards its responses.
+type mirror struct {
+	pool     *backendPool
+	percent  float64
+	maxBody  int64
+	timeout  time.Duration
+	inFlight chan struct{}
+	// random returns a random number in [0, 1), replaceable for tests.
+	random func() float64
+}
+
+func newMirror(mirrorCfg *pb.Mirror, pools map[string]*backendPool) (*mirror, error) {
+	pool, present := pools[mirrorCfg.GetPool()]
+	if !present {
+		return nil, fmt.Errorf("mirror uses unknown pool %q", mirrorCfg.GetPool())
+	}
+	m := &mirror{
+		pool:     pool,
+		percent:  100,
+		maxBody:  defaultMirrorMaxBody,
+		timeout:  defaultMirrorTimeout,
+		inFlight: make(chan struct{}, maxMirrorsInFlight),
+		random:   rand.Float64,
+	}
+	if mirrorCfg.Percent != nil {
+		if mirrorCfg.GetPercent() < 0 || mirrorCfg.GetPercent() > 100 {
+			return nil, fmt.Errorf("mirror percent must be between 0 and 100, got %v", mirrorCfg.GetPercent())
+		}
+		m.percent = mirrorCfg.GetPercent()
+	}
+	if mirrorCfg.GetMaxBodyBytes() > 0 {
+		m.maxBody = mirrorCfg.GetMaxBodyBytes()
+	}
+	if mirrorCfg.GetTimeout() != nil {
+		m.timeout = mirrorCfg.GetTimeout().AsDuration()
+	}
+	return m, nil
+}
+
+// discardResponse is a ResponseWriter dropping everything written to it.
+type discardResponse struct {
+	header http.Header
+}
+
+func (d *discardResponse) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponse) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discardResponse) WriteHeader(statusCode int) {}
+
+// recordingBody passes the body of a request through, keeping a copy
+// of it unless it is larger than max.
+type recordingBody struct {
+	io.ReadCloser
+	max int64
+
+	// The body may still be read by the transport after the response.
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	tooLarge bool
+	done     bool
+}
+
+func (rb *recordingBody) Read(p []byte) (int, error) {
+	n, err := rb.ReadCloser.Read(p)
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.tooLarge {
+		if int64(rb.buf.Len()+n) > rb.max {
+			rb.tooLarge = true
+			rb.buf = bytes.Buffer{}
+		} else {
+			rb.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		rb.done = true
+	}
+	return n, err
+}
+
+// recorded returns the whole body, or false if it was not read to the
+// end or is too large.
+func (rb *recordingBody) recorded() ([]byte, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.done || rb.tooLarge {
+		return nil, false
+	}
+	return rb.buf.Bytes(), true
+}
+
+// copy prepares a copy of r for the shadow pool, if r is sampled, and
+// returns the function sending it in the background, to call once the
+// primary request is done. The body is recorded while the primary request
+// reads it, so the copy never holds back the primary request, and it is
+// not sent if the primary request did not read the whole body.
+func (m *mirror) copy(r *http.Request) (send func()) {
+	if m.random()*100 >= m.percent || r.ContentLength > m.maxBody {
+		return func() {}
+	}
+	shadow := r.Clone(context.Background())
+	shadow.Header.Set(mirrorHeader, "true")
+	var body *recordingBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &recordingBody{ReadCloser: r.Body, max: m.maxBody}
+		r.Body = body
+	}
+
+	return func() {
+		shadow.Body, shadow.ContentLength = http.NoBody, 0
+		if body != nil {
+			recorded, ok := body.recorded()
+			if !ok {
+				return
+			}
+			shadow.Body = ioutil.NopCloser(bytes.NewReader(recorded))
+			shadow.ContentLength = int64(len(recorded))
+		}
+		select {
+		case m.inFlight <- struct{}{}:
+		default:
+			log.Printf("Dropping the mirror of %v, too many mirrored requests in flight", r.URL)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		go func() {
+			defer func() { <-m.inFlight }()
+			defer cancel()
+			m.pool.ServeHTTP(&discardResponse{header: make(http.Header)}, shadow.WithContext(ctx))
+		}()
+	}
+}
+
+// bufferBody reads the body of r in memory and puts it back on r, so it
+// can be sent twice. It returns false for bodies larger than maxBody,
+// leaving r with its whole body.
+func bufferBody(r *http.Request, maxBody int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	} else if r.ContentLength > maxBody {
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil || int64(len(body)) > maxBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{bytes.NewReader(body), r.Body}
+	return body, true
+}
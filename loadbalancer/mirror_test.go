@@ -0,0 +1,167 @@
+package loadbalancer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordingBackend keeps the bodies and mirror headers of its requests.
+type recordingBackend struct {
+	server *httptest.Server
+	// release blocks the requests until it is closed, if set.
+	release chan struct{}
+	// handled receives a value once each request is recorded.
+	handled chan struct{}
+	mu      sync.Mutex
+	bodies  []string
+	mirrors []string
+}
+
+func newRecordingBackend(release chan struct{}) *recordingBackend {
+	be := &recordingBackend{release: release, handled: make(chan struct{}, 10)}
+	be.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if be.release != nil {
+			<-be.release
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		be.mu.Lock()
+		be.bodies = append(be.bodies, string(body))
+		be.mirrors = append(be.mirrors, r.Header.Get(mirrorHeader))
+		be.mu.Unlock()
+		be.handled <- struct{}{}
+		w.Write([]byte("OK"))
+	}))
+	return be
+}
+
+func (be *recordingBackend) received() ([]string, []string) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.bodies, be.mirrors
+}
+
+func TestMirror(t *testing.T) {
+	tests := []struct {
+		name   string
+		mirror *pb.Mirror
+		body   string
+		// unknownLength hides the length of the body, as in chunked uploads.
+		unknownLength bool
+		wantMirrored  bool
+	}{
+		{
+			name:         "Copies the request and its body",
+			mirror:       &pb.Mirror{Pool: proto.String("shadow")},
+			body:         "hello",
+			wantMirrored: true,
+		},
+		{
+			name:   "Not sampled",
+			mirror: &pb.Mirror{Pool: proto.String("shadow"), Percent: proto.Float64(0)},
+			body:   "hello",
+		},
+		{
+			name:   "Body too large",
+			mirror: &pb.Mirror{Pool: proto.String("shadow"), MaxBodyBytes: proto.Int64(4)},
+			body:   "hello",
+		},
+		{
+			name:          "Body of unknown length",
+			mirror:        &pb.Mirror{Pool: proto.String("shadow")},
+			body:          "hello",
+			unknownLength: true,
+			wantMirrored:  true,
+		},
+		{
+			name:          "Body of unknown length too large",
+			mirror:        &pb.Mirror{Pool: proto.String("shadow"), MaxBodyBytes: proto.Int64(4)},
+			body:          "hello",
+			unknownLength: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release := make(chan struct{})
+			primary := newRecordingBackend(nil)
+			defer primary.server.Close()
+			shadow := newRecordingBackend(release)
+			defer shadow.server.Close()
+
+			cfg := &pb.Config{
+				Backend: &pb.BackendConfig{
+					Type: &pb.BackendConfig_Static{
+						Static: &pb.StaticBackends{Urls: []string{primary.server.URL}},
+					},
+				},
+				Pools: []*pb.BackendPool{
+					{
+						Name: proto.String("shadow"),
+						Backend: &pb.BackendConfig{
+							Type: &pb.BackendConfig_Static{
+								Static: &pb.StaticBackends{Urls: []string{shadow.server.URL}},
+							},
+						},
+					},
+				},
+				Routes: []*pb.Route{
+					{Pool: proto.String(defaultPool), Mirror: test.mirror},
+				},
+			}
+			lb, err := New(cfg)
+			if err != nil {
+				t.Fatalf("Error creating LB: %v", err)
+			}
+			lb.pools[defaultPool].lbAlgo.(backendLookup).Lookup(primary.server.URL).SetAlive(true)
+			lb.pools["shadow"].lbAlgo.(backendLookup).Lookup(shadow.server.URL).SetAlive(true)
+
+			req := httptest.NewRequest("POST", "/upload", strings.NewReader(test.body))
+			if test.unknownLength {
+				req.Body = ioutil.NopCloser(strings.NewReader(test.body))
+				req.ContentLength = -1
+			}
+			resp := httptest.NewRecorder()
+			// The shadow backend is blocked, so this only returns if the
+			// primary response does not wait for the mirrored one.
+			lb.ServeHTTP(resp, req)
+			if resp.Code != http.StatusOK {
+				t.Errorf("want status OK, got %v", resp.Code)
+			}
+			if bodies, mirrors := primary.received(); len(bodies) != 1 || bodies[0] != test.body || mirrors[0] != "" {
+				t.Errorf("primary want one %q request without mirror header, got %q %q", test.body, bodies, mirrors)
+			}
+
+			close(release)
+			if !test.wantMirrored {
+				// The copy is skipped once the primary request is done.
+				if bodies, _ := shadow.received(); len(bodies) != 0 {
+					t.Errorf("shadow want no requests, got %q", bodies)
+				}
+				return
+			}
+			select {
+			case <-shadow.handled:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("shadow got no mirrored request")
+			}
+			bodies, mirrors := shadow.received()
+			if len(bodies) != 1 || bodies[0] != test.body || mirrors[0] != "true" {
+				t.Errorf("shadow want one mirrored %q request, got %q %q", test.body, bodies, mirrors)
+			}
+		})
+	}
+}
+
+func TestMirrorUnknownPool(t *testing.T) {
+	if _, err := newMirror(&pb.Mirror{Pool: proto.String("missing")}, splitPools()); err == nil {
+		t.Errorf("newMirror() want error for an unknown pool, got nil")
+	}
+}
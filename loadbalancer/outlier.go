@@ -0,0 +1,193 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const (
+	defaultOutlierInterval    = 10 * time.Second
+	defaultOutlierFailures    = 5
+	defaultOutlierMinRequests = 10
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionTime    = 300 * time.Second
+	defaultMaxEjectionPercent = 10
+	outlierReasonFailures     = "consecutive failures"
+	outlierReasonFailureRate  = "failure rate"
+	outlierReasonLatency      = "latency"
+)
+
+// outlierDetector ejects the backends of a pool misbehaving with the live
+// traffic, analyzing them all at once every interval.
+type outlierDetector struct {
+	pool                string
+	interval            time.Duration
+	consecutiveFailures int64
+	// failureRate and latencyFactor are 0 when not used.
+	failureRate        float64
+	latencyFactor      float64
+	minRequests        int64
+	baseEjection       time.Duration
+	maxEjection        time.Duration
+	maxEjectionPercent int
+	now                func() time.Time
+
+	mu sync.Mutex
+	// ejections counts the recent ejections of each backend, to make
+	// each one longer than the last. It decreases every interval the
+	// backend is not ejected.
+	ejections map[*algos.Backend]int
+}
+
+func newOutlierDetector(pool string, outlierCfg *pb.OutlierDetection) (*outlierDetector, error) {
+	d := &outlierDetector{
+		pool:                pool,
+		interval:            defaultOutlierInterval,
+		consecutiveFailures: defaultOutlierFailures,
+		minRequests:         defaultOutlierMinRequests,
+		baseEjection:        defaultBaseEjectionTime,
+		maxEjection:         defaultMaxEjectionTime,
+		maxEjectionPercent:  defaultMaxEjectionPercent,
+		now:                 time.Now,
+		ejections:           make(map[*algos.Backend]int),
+	}
+	if outlierCfg.GetInterval() != nil {
+		if d.interval = outlierCfg.GetInterval().AsDuration(); d.interval <= 0 {
+			return nil, fmt.Errorf("outlier detection interval must be positive, got %v", d.interval)
+		}
+	}
+	if outlierCfg.ConsecutiveFailures != nil {
+		if outlierCfg.GetConsecutiveFailures() < 1 {
+			return nil, fmt.Errorf("outlier detection consecutive failures must be at least 1, got %v",
+				outlierCfg.GetConsecutiveFailures())
+		}
+		d.consecutiveFailures = int64(outlierCfg.GetConsecutiveFailures())
+	}
+	if outlierCfg.FailureRate != nil {
+		if outlierCfg.GetFailureRate() <= 0 || outlierCfg.GetFailureRate() > 1 {
+			return nil, fmt.Errorf("outlier detection failure rate must be in (0, 1], got %v",
+				outlierCfg.GetFailureRate())
+		}
+		d.failureRate = outlierCfg.GetFailureRate()
+	}
+	if outlierCfg.MinRequests != nil {
+		d.minRequests = int64(outlierCfg.GetMinRequests())
+	}
+	if outlierCfg.LatencyFactor != nil {
+		if outlierCfg.GetLatencyFactor() <= 1 {
+			return nil, fmt.Errorf("outlier detection latency factor must be above 1, got %v",
+				outlierCfg.GetLatencyFactor())
+		}
+		d.latencyFactor = outlierCfg.GetLatencyFactor()
+	}
+	if outlierCfg.GetBaseEjectionTime() != nil {
+		d.baseEjection = outlierCfg.GetBaseEjectionTime().AsDuration()
+	}
+	if outlierCfg.GetMaxEjectionTime() != nil {
+		d.maxEjection = outlierCfg.GetMaxEjectionTime().AsDuration()
+	}
+	if d.baseEjection <= 0 || d.maxEjection < d.baseEjection {
+		return nil, fmt.Errorf("outlier detection needs 0 < base ejection time <= max ejection time, got %v and %v",
+			d.baseEjection, d.maxEjection)
+	}
+	if outlierCfg.MaxEjectionPercent != nil {
+		if outlierCfg.GetMaxEjectionPercent() < 0 || outlierCfg.GetMaxEjectionPercent() > 100 {
+			return nil, fmt.Errorf("outlier detection max ejection percent must be between 0 and 100, got %v",
+				outlierCfg.GetMaxEjectionPercent())
+		}
+		d.maxEjectionPercent = int(outlierCfg.GetMaxEjectionPercent())
+	}
+	return d, nil
+}
+
+// maxEjected returns how many of n backends can be ejected at once, at
+// least one unless the percentage is 0, but never all of them.
+func (d *outlierDetector) maxEjected(n int) int {
+	max := n * d.maxEjectionPercent / 100
+	if max < 1 && d.maxEjectionPercent > 0 {
+		max = 1
+	}
+	if max > n-1 {
+		max = n - 1
+	}
+	return max
+}
+
+// outlierReason returns why a backend with stats is an outlier, or an
+// empty string if it is not one.
+func (d *outlierDetector) outlierReason(stats algos.TrafficStats, medianLatency time.Duration) string {
+	if stats.ConsecutiveFailures >= d.consecutiveFailures {
+		return outlierReasonFailures
+	} else if stats.Requests < d.minRequests || stats.Requests == 0 {
+		return ""
+	}
+	if d.failureRate > 0 && float64(stats.Failures) >= d.failureRate*float64(stats.Requests) {
+		return outlierReasonFailureRate
+	}
+	if d.latencyFactor > 0 && medianLatency > 0 &&
+		float64(stats.MeanLatency) > d.latencyFactor*float64(medianLatency) {
+		return outlierReasonLatency
+	}
+	return ""
+}
+
+// analyze ejects the outliers among backends, from the traffic they
+// got since the last analysis.
+func (d *outlierDetector) analyze(_ context.Context, backends []*algos.Backend) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+
+	stats := make([]algos.TrafficStats, len(backends))
+	var latencies []time.Duration
+	ejected := 0
+	for i, be := range backends {
+		stats[i] = be.TakeTrafficStats()
+		if be.IsEjected(now) {
+			ejected++
+		} else if stats[i].Requests >= d.minRequests && stats[i].MeanLatency > 0 {
+			latencies = append(latencies, stats[i].MeanLatency)
+		}
+	}
+	var medianLatency time.Duration
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		medianLatency = latencies[len(latencies)/2]
+	}
+
+	present := make(map[*algos.Backend]bool)
+	for i, be := range backends {
+		present[be] = true
+		if be.IsEjected(now) {
+			continue
+		}
+		reason := d.outlierReason(stats[i], medianLatency)
+		if len(reason) == 0 || ejected >= d.maxEjected(len(backends)) {
+			if d.ejections[be] > 0 && len(reason) == 0 {
+				d.ejections[be]--
+			}
+			continue
+		}
+		d.ejections[be]++
+		ejection := time.Duration(d.ejections[be]) * d.baseEjection
+		if ejection > d.maxEjection {
+			ejection = d.maxEjection
+		}
+		be.Eject(now.Add(ejection))
+		ejected++
+		log.Printf("Ejecting %v from pool %v for %v due to its %v", be.URL(), d.pool, ejection, reason)
+	}
+	// Forget the backends that left the pool.
+	for be := range d.ejections {
+		if !present[be] {
+			delete(d.ejections, be)
+		}
+	}
+}
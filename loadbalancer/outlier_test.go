@@ -0,0 +1,188 @@
+package loadbalancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// trafficServer fails every failEvery-th request, or none if failEvery
+// is 0, and answers after delay.
+func trafficServer(t *testing.T, failEvery int64, delay time.Duration) *algos.Backend {
+	t.Helper()
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		if n := atomic.AddInt64(&count, 1); failEvery > 0 && n%failEvery == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	be, err := algos.NewBackend(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	be.SetAlive(true)
+	return be
+}
+
+func sendTraffic(t *testing.T, be *algos.Backend, requests int) {
+	t.Helper()
+	for i := 0; i < requests; i++ {
+		handler, ok := be.GetOpenConnection(nil)
+		if !ok {
+			t.Fatalf("want %v ready", be.URL())
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	tests := []struct {
+		name        string
+		outliers    *pb.OutlierDetection
+		failEvery   []int64
+		delays      []time.Duration
+		wantEjected []bool
+	}{
+		{
+			name:        "Consecutive failures",
+			outliers:    &pb.OutlierDetection{ConsecutiveFailures: proto.Int32(3), MaxEjectionPercent: proto.Int32(50)},
+			failEvery:   []int64{1, 0, 0, 0},
+			wantEjected: []bool{true, false, false, false},
+		},
+		{
+			name: "Failure rate",
+			outliers: &pb.OutlierDetection{
+				FailureRate:        proto.Float64(0.5),
+				MaxEjectionPercent: proto.Int32(50),
+			},
+			failEvery:   []int64{2, 0, 0, 0},
+			wantEjected: []bool{true, false, false, false},
+		},
+		{
+			name: "Latency",
+			outliers: &pb.OutlierDetection{
+				LatencyFactor:      proto.Float64(3),
+				MaxEjectionPercent: proto.Int32(50),
+			},
+			failEvery:   []int64{0, 0, 0, 0},
+			delays:      []time.Duration{0, 0, 0, 20 * time.Millisecond},
+			wantEjected: []bool{false, false, false, true},
+		},
+		{
+			name:        "Max ejection percent",
+			outliers:    &pb.OutlierDetection{MaxEjectionPercent: proto.Int32(50)},
+			failEvery:   []int64{1, 1, 1, 0},
+			wantEjected: []bool{true, true, false, false},
+		},
+		{
+			name:        "No ejections",
+			outliers:    &pb.OutlierDetection{MaxEjectionPercent: proto.Int32(0)},
+			failEvery:   []int64{1, 0, 0, 0},
+			wantEjected: []bool{false, false, false, false},
+		},
+		{
+			name:        "Small percent ejects one",
+			outliers:    &pb.OutlierDetection{MaxEjectionPercent: proto.Int32(1)},
+			failEvery:   []int64{1, 1, 0, 0},
+			wantEjected: []bool{true, false, false, false},
+		},
+		{
+			name:        "Never the whole pool",
+			outliers:    &pb.OutlierDetection{MaxEjectionPercent: proto.Int32(100)},
+			failEvery:   []int64{1, 1},
+			wantEjected: []bool{true, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := newOutlierDetector("test", test.outliers)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			var backends []*algos.Backend
+			for i, failEvery := range test.failEvery {
+				var delay time.Duration
+				if test.delays != nil {
+					delay = test.delays[i]
+				}
+				be := trafficServer(t, failEvery, delay)
+				sendTraffic(t, be, 10)
+				backends = append(backends, be)
+			}
+
+			d.analyze(context.Background(), backends)
+
+			for i, be := range backends {
+				if got := !be.IsAliveAndReady(); got != test.wantEjected[i] {
+					t.Errorf("backend %v want ejected %v, got %v", i, test.wantEjected[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionsGrow(t *testing.T) {
+	d, err := newOutlierDetector("test", &pb.OutlierDetection{
+		ConsecutiveFailures: proto.Int32(1),
+		BaseEjectionTime:    durationpb.New(30 * time.Second),
+		MaxEjectionTime:     durationpb.New(time.Minute),
+		MaxEjectionPercent:  proto.Int32(50),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	failing := trafficServer(t, 1, 0)
+	backends := []*algos.Backend{failing, trafficServer(t, 0, 0)}
+
+	wantEjections := []time.Duration{30 * time.Second, time.Minute, time.Minute}
+	for i, want := range wantEjections {
+		sendTraffic(t, failing, 1)
+		d.analyze(context.Background(), backends)
+		if !failing.IsEjected(now.Add(want-time.Second)) || failing.IsEjected(now.Add(want)) {
+			t.Errorf("ejection %v want to last %v", i+1, want)
+		}
+		// End the ejection early to fail again.
+		now = now.Add(want)
+		failing.Eject(time.Now())
+	}
+}
+
+func TestNewOutlierDetectorErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		outliers *pb.OutlierDetection
+	}{
+		{name: "No failures", outliers: &pb.OutlierDetection{ConsecutiveFailures: proto.Int32(0)}},
+		{name: "Failure rate above 1", outliers: &pb.OutlierDetection{FailureRate: proto.Float64(2)}},
+		{name: "Latency factor below 1", outliers: &pb.OutlierDetection{LatencyFactor: proto.Float64(0.5)}},
+		{
+			name: "Max ejection below base",
+			outliers: &pb.OutlierDetection{
+				BaseEjectionTime: durationpb.New(time.Minute),
+				MaxEjectionTime:  durationpb.New(time.Second),
+			},
+		},
+		{name: "Percent above 100", outliers: &pb.OutlierDetection{MaxEjectionPercent: proto.Int32(101)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newOutlierDetector("test", test.outliers); err == nil {
+				t.Errorf("newOutlierDetector() want error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultPool is the name of the pool made of the top level backend config.
+const defaultPool = "default"
+
+// backendPool is a group of backends balanced by one algorithm,
+// with its own health checks and membership.
+type backendPool struct {
+	name        string
+	beCfg       *pb.BackendConfig
+	healthCheck *pb.HealthCheck
+	lbAlgo      lbAlgorithm
+	deadCounter *deadCounter
+	// retry is nil if the failed requests are not retried.
+	retry *retryPolicy
+	// outliers is nil if the pool has no outlier detection.
+	outliers *outlierDetector
+}
+
+func newAlgorithm(algorithm pb.BalancingAlgorithm, beCfg *pb.BackendConfig) (lbAlgorithm, error) {
+	var lbAlgo lbAlgorithm
+	var err error
+	switch algorithm {
+	case pb.BalancingAlgorithm_LeastConnections:
+		lbAlgo, err = algos.NewLeastConnections(beCfg)
+	case pb.BalancingAlgorithm_LowestLatency:
+		lbAlgo, err = algos.NewLowestLatency(beCfg)
+	case pb.BalancingAlgorithm_ResourceBased:
+		lbAlgo, err = algos.NewResourceBased(beCfg)
+	case pb.BalancingAlgorithm_WeightedRoundRobin:
+		lbAlgo, err = algos.NewWeightedRoundRobin(beCfg)
+	case pb.BalancingAlgorithm_ConsistentHash:
+		lbAlgo, err = algos.NewConsistentHash(beCfg)
+	case pb.BalancingAlgorithm_Maglev:
+		lbAlgo, err = algos.NewMaglev(beCfg)
+	case pb.BalancingAlgorithm_P2C:
+		lbAlgo, err = algos.NewP2C(beCfg)
+	case pb.BalancingAlgorithm_ModuloHash:
+		lbAlgo, err = algos.NewModuloHash(beCfg)
+	default:
+		lbAlgo, err = algos.NewRoundRobin(beCfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if beCfg.GetSessionAffinity() != nil {
+		return newStickySessions(lbAlgo, beCfg)
+	}
+	return lbAlgo, nil
+}
+
+func newBackendPool(name string, algorithm pb.BalancingAlgorithm,
+	beCfg *pb.BackendConfig, healthCheck *pb.HealthCheck) (*backendPool, error) {
+	lbAlgo, err := newAlgorithm(algorithm, beCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating pool %v: %v", name, err)
+	}
+	if command := healthCheck.GetProbe().GetCommand(); command != nil && len(command.GetPath()) == 0 {
+		return nil, fmt.Errorf("error creating pool %v: command health probes need a path", name)
+	}
+	var retry *retryPolicy
+	if beCfg.GetRetry() != nil {
+		if retry, err = newRetryPolicy(beCfg.GetRetry()); err != nil {
+			return nil, fmt.Errorf("error creating pool %v: %v", name, err)
+		}
+	}
+	var outliers *outlierDetector
+	if beCfg.GetOutlierDetection() != nil {
+		if outliers, err = newOutlierDetector(name, beCfg.GetOutlierDetection()); err != nil {
+			return nil, fmt.Errorf("error creating pool %v: %v", name, err)
+		}
+	}
+	return &backendPool{
+		name:        name,
+		beCfg:       beCfg,
+		healthCheck: healthCheck,
+		lbAlgo:      lbAlgo,
+		retry:       retry,
+		outliers:    outliers,
+	}, nil
+}
+
+func (p *backendPool) RegisterNew(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received register request for pool %v", p.name)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	}
+	regReq := &pb.RegisterRequest{}
+	if err := proto.Unmarshal(body, regReq); err != nil {
+		log.Printf("Failed to parse register request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	} else if len(regReq.GetHost()) == 0 {
+		log.Printf("Received register request without host: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must have host set"))
+		return
+	}
+
+	var rawUrl string
+	if regReq.Port != nil {
+		rawUrl = fmt.Sprintf("http://%v:%v", regReq.GetHost(), regReq.GetPort())
+	} else {
+		rawUrl = fmt.Sprintf("http://%v", regReq.GetHost())
+	}
+
+	if weighted, ok := p.lbAlgo.(weightedRegistrar); ok && regReq.Weight != nil {
+		err = weighted.RegisterWeighted(rawUrl, regReq.GetWeight())
+	} else {
+		err = p.lbAlgo.Register(rawUrl)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error handling register"))
+	} else {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Registered"))
+	}
+}
+
+func (p *backendPool) Deregister(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received deregister request for pool %v", p.name)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	}
+	regReq := &pb.DeregisterRequest{}
+	if err := proto.Unmarshal(body, regReq); err != nil {
+		log.Printf("Failed to parse unregister request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	} else if len(regReq.GetHost()) == 0 {
+		log.Printf("Received unregister request without host: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must have host set"))
+		return
+	}
+
+	var rawUrl string
+	if regReq.Port != nil {
+		rawUrl = fmt.Sprintf("http://%v:%v", regReq.GetHost(), regReq.GetPort())
+	} else {
+		rawUrl = fmt.Sprintf("http://%v", regReq.GetHost())
+	}
+
+	if err := p.lbAlgo.Deregister(rawUrl); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error handling deregister"))
+	} else {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Registered"))
+	}
+}
+
+// ServeHTTP balances the request over the backends of the pool,
+// retrying it if the pool has a retry policy allowing it.
+func (p *backendPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.retry != nil {
+		p.retry.budget.request()
+		if body, ok := p.retry.retryable(r); ok {
+			p.retry.serve(w, r, p.lbAlgo, body)
+			return
+		}
+	}
+	p.lbAlgo.Handler(r).ServeHTTP(w, r)
+}
@@ -0,0 +1,267 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const (
+	defaultRetryAttempts = 2
+	defaultRetryMaxBody  = 64 << 10
+)
+
+var (
+	defaultRetryOn      = []int32{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	defaultRetryMethods = []string{http.MethodGet, http.MethodHead}
+)
+
+// retryPolicy sends the failed requests of a pool again,
+// on other backends when the algorithm allows it.
+type retryPolicy struct {
+	maxAttempts int
+	retryOn     map[int]bool
+	perTry      time.Duration
+	methods     map[string]bool
+	maxBody     int64
+	budget      *retryBudget
+}
+
+func newRetryPolicy(retryCfg *pb.RetryPolicy) (*retryPolicy, error) {
+	rp := &retryPolicy{
+		maxAttempts: defaultRetryAttempts,
+		retryOn:     make(map[int]bool),
+		methods:     make(map[string]bool),
+		maxBody:     defaultRetryMaxBody,
+	}
+	if retryCfg.MaxAttempts != nil {
+		if retryCfg.GetMaxAttempts() < 1 {
+			return nil, fmt.Errorf("retry max attempts must be at least 1, got %v", retryCfg.GetMaxAttempts())
+		}
+		rp.maxAttempts = int(retryCfg.GetMaxAttempts())
+	}
+	retryOn := retryCfg.GetRetryOn()
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	for _, status := range retryOn {
+		if status < 100 || status > 999 {
+			return nil, fmt.Errorf("invalid retry status %v", status)
+		}
+		rp.retryOn[int(status)] = true
+	}
+	if retryCfg.GetPerTryTimeout() != nil {
+		rp.perTry = retryCfg.GetPerTryTimeout().AsDuration()
+	}
+	methods := retryCfg.GetMethods()
+	if len(methods) == 0 {
+		methods = defaultRetryMethods
+	}
+	for _, method := range methods {
+		rp.methods[strings.ToUpper(method)] = true
+	}
+	if retryCfg.GetMaxBodyBytes() > 0 {
+		rp.maxBody = retryCfg.GetMaxBodyBytes()
+	}
+	budget, err := newRetryBudget(retryCfg.GetBudget())
+	if err != nil {
+		return nil, err
+	}
+	rp.budget = budget
+	return rp, nil
+}
+
+// retryWriter holds back the response of an attempt until its status is
+// known, dropping it if the status is retried. The body of a dropped
+// response is kept up to maxBody, so it can still be passed on if no
+// other attempt is made.
+type retryWriter struct {
+	w http.ResponseWriter
+	// retryOn are the statuses dropped, nil on the last attempt.
+	retryOn map[int]bool
+	header  http.Header
+	// status is the status of the attempt, 0 until it is written.
+	status   int
+	dropped  bool
+	maxBody  int64
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func newRetryWriter(w http.ResponseWriter, retryOn map[int]bool, maxBody int64) *retryWriter {
+	return &retryWriter{w: w, retryOn: retryOn, header: make(http.Header), maxBody: maxBody}
+}
+
+// Header returns the header of the client response once the status is
+// passed on, so the trailers set after the body reach the client.
+func (rw *retryWriter) Header() http.Header {
+	if rw.status != 0 && !rw.dropped {
+		return rw.w.Header()
+	}
+	return rw.header
+}
+
+func (rw *retryWriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	if rw.retryOn[status] {
+		rw.dropped = true
+		return
+	}
+	for name, values := range rw.header {
+		rw.w.Header()[name] = values
+	}
+	rw.w.WriteHeader(status)
+}
+
+func (rw *retryWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.dropped {
+		if !rw.tooLarge && int64(rw.body.Len()+len(b)) <= rw.maxBody {
+			rw.body.Write(b)
+		} else {
+			rw.tooLarge = true
+			rw.body.Reset()
+		}
+		return len(b), nil
+	}
+	return rw.w.Write(b)
+}
+
+// passOn writes the dropped response to the client after all, or
+// reports false if its body was too large to keep.
+func (rw *retryWriter) passOn() bool {
+	if !rw.dropped || rw.tooLarge {
+		return false
+	}
+	for name, values := range rw.header {
+		rw.w.Header()[name] = values
+	}
+	rw.w.WriteHeader(rw.status)
+	rw.w.Write(rw.body.Bytes())
+	return true
+}
+
+func (rw *retryWriter) Flush() {
+	if flusher, ok := rw.w.(http.Flusher); ok && rw.status != 0 && !rw.dropped {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the client connection, e.g. for protocol upgrades.
+func (rw *retryWriter) Unwrap() http.ResponseWriter {
+	return rw.w
+}
+
+// retryable reports if r can be sent more than once, buffering its body.
+func (rp *retryPolicy) retryable(r *http.Request) ([]byte, bool) {
+	if rp.maxAttempts < 2 || !rp.methods[r.Method] {
+		return nil, false
+	}
+	return bufferBody(r, rp.maxBody)
+}
+
+// pick asks the algorithm for a backend not in tried, settling for
+// a tried one when no other backend is available.
+func pick(lbAlgo lbAlgorithm, r *http.Request, tried map[*algos.Backend]bool) http.Handler {
+	if len(tried) == 0 {
+		return lbAlgo.Handler(r)
+	}
+	handler := lbAlgo.Handler(algos.WithExcluded(r, tried))
+	if algos.BackendOf(handler) != nil {
+		return handler
+	}
+	// The algorithm would back off waiting for the tried backends if they
+	// all went down since.
+	for be := range tried {
+		if be.IsAliveAndReady() {
+			return lbAlgo.Handler(r)
+		}
+	}
+	return handler
+}
+
+// serve sends r to the pool until an attempt succeeds, or the attempts,
+// the retry budget or the backends to pick run out. Connection failures
+// and timeouts of the attempts are always retried, the responses only if
+// their status is.
+func (rp *retryPolicy) serve(w http.ResponseWriter, r *http.Request, lbAlgo lbAlgorithm, body []byte) {
+	tried := make(map[*algos.Backend]bool)
+	// The previous attempt, and its error if it failed without a response,
+	// are passed on if no backend is left for the next one.
+	var prev *retryWriter
+	var errStatus int
+	var errBody string
+	for attempt := 1; ; attempt++ {
+		var last, failed bool
+		ctx, cancel := r.Context(), context.CancelFunc(func() {})
+		if rp.perTry > 0 {
+			ctx, cancel = context.WithTimeout(ctx, rp.perTry)
+		}
+		try := algos.WithErrorWriter(r.WithContext(ctx),
+			func(aw http.ResponseWriter, _ *http.Request, status int, defaultBody string) {
+				failed = true
+				errStatus, errBody = status, defaultBody
+				if last || r.Context().Err() != nil {
+					algos.WriteError(aw, r, status, defaultBody)
+				}
+			})
+		if r.Body != nil && r.Body != http.NoBody {
+			try.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		handler := pick(lbAlgo, try, tried)
+		be := algos.BackendOf(handler)
+		if attempt > 1 {
+			if be == nil {
+				// Retrying only gets the same error, which is not counted
+				// as a retry.
+				cancel()
+				if !prev.passOn() {
+					// The response was too large to keep, or there was none.
+					if errStatus == 0 {
+						errStatus, errBody = http.StatusServiceUnavailable, "No available service\n"
+					}
+					algos.WriteError(w, r, errStatus, errBody)
+				}
+				return
+			}
+			rp.budget.retry()
+		}
+		// The budget is checked before the attempt, since the response of
+		// the last attempt is passed on whatever its status.
+		exhausted := attempt < rp.maxAttempts && !rp.budget.canRetry()
+		last = attempt == rp.maxAttempts || exhausted || be == nil
+
+		var retryOn map[int]bool
+		if !last {
+			retryOn = rp.retryOn
+		}
+		rw := newRetryWriter(w, retryOn, rp.maxBody)
+		if be != nil {
+			tried[be] = true
+		}
+		errStatus = 0
+		handler.ServeHTTP(rw, try)
+		cancel()
+		prev = rw
+
+		if exhausted && (failed || rp.retryOn[rw.status]) {
+			rp.budget.reject()
+		}
+		if last || (!failed && !rw.dropped) || r.Context().Err() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// countingBackend answers with a fixed status and keeps the request bodies.
+type countingBackend struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newCountingBackend(status int, delay time.Duration) *countingBackend {
+	be := &countingBackend{}
+	be.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		be.mu.Lock()
+		be.bodies = append(be.bodies, string(body))
+		be.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status)))
+	}))
+	return be
+}
+
+func (be *countingBackend) received() []string {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.bodies
+}
+
+func retryPool(t *testing.T, retry *pb.RetryPolicy, urls ...string) *backendPool {
+	t.Helper()
+	pool, err := newBackendPool("retried", pb.BalancingAlgorithm_RoundRobin, &pb.BackendConfig{
+		Type:  &pb.BackendConfig_Static{Static: &pb.StaticBackends{Urls: urls}},
+		Retry: retry,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	for _, url := range urls {
+		pool.lbAlgo.(backendLookup).Lookup(url).SetAlive(true)
+	}
+	return pool
+}
+
+func TestRetryOnOtherBackend(t *testing.T) {
+	unavailable := newCountingBackend(http.StatusServiceUnavailable, 0)
+	defer unavailable.server.Close()
+	ok := newCountingBackend(http.StatusOK, 0)
+	defer ok.server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		failed string
+	}{
+		{name: "Retried status", failed: unavailable.server.URL},
+		{name: "Connection failure", failed: closed.URL},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := retryPool(t, &pb.RetryPolicy{}, test.failed, ok.server.URL)
+			for i := 0; i < 4; i++ {
+				resp := httptest.NewRecorder()
+				pool.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+				if resp.Code != http.StatusOK {
+					t.Errorf("want status %v, got %v", http.StatusOK, resp.Code)
+				}
+				if resp.Body.String() != "OK" {
+					t.Errorf("want body OK, got %v", resp.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAvoidsTriedBackends(t *testing.T) {
+	unavailable := newCountingBackend(http.StatusServiceUnavailable, 0)
+	defer unavailable.server.Close()
+	ok := newCountingBackend(http.StatusOK, 0)
+	defer ok.server.Close()
+
+	tests := []struct {
+		name      string
+		algorithm pb.BalancingAlgorithm
+		affinity  bool
+	}{
+		{name: "Lowest latency", algorithm: pb.BalancingAlgorithm_LowestLatency},
+		{name: "Maglev", algorithm: pb.BalancingAlgorithm_Maglev},
+		{name: "Consistent hash", algorithm: pb.BalancingAlgorithm_ConsistentHash},
+		{name: "Modulo hash", algorithm: pb.BalancingAlgorithm_ModuloHash},
+		{name: "Least connections", algorithm: pb.BalancingAlgorithm_LeastConnections},
+		{name: "Resource based", algorithm: pb.BalancingAlgorithm_ResourceBased},
+		{name: "Weighted round robin", algorithm: pb.BalancingAlgorithm_WeightedRoundRobin},
+		{name: "Maglev with sticky sessions", algorithm: pb.BalancingAlgorithm_Maglev, affinity: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urls := []string{unavailable.server.URL, ok.server.URL}
+			beCfg := &pb.BackendConfig{
+				Type:       &pb.BackendConfig_Static{Static: &pb.StaticBackends{Urls: urls}},
+				HashPolicy: &pb.HashPolicy{Source: pb.HashPolicy_PATH.Enum()},
+				Retry:      &pb.RetryPolicy{MaxAttempts: proto.Int32(3)},
+			}
+			if test.affinity {
+				beCfg.SessionAffinity = &pb.SessionAffinity{SigningKey: proto.String("secret")}
+			}
+			pool, err := newBackendPool("retried", test.algorithm, beCfg, nil)
+			if err != nil {
+				t.Fatalf("Error creating pool: %v", err)
+			}
+			for _, url := range urls {
+				pool.lbAlgo.(backendLookup).Lookup(url).SetAlive(true)
+			}
+
+			failed := 0
+			for i := 0; i < 20; i++ {
+				resp := httptest.NewRecorder()
+				pool.ServeHTTP(resp, httptest.NewRequest("GET", fmt.Sprintf("/%v", i), nil))
+				if resp.Code != http.StatusOK {
+					failed++
+				}
+			}
+			if failed > 0 {
+				t.Errorf("want all the requests retried on the healthy backend, %v of 20 failed", failed)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		retry        *pb.RetryPolicy
+		status       int
+		delay        time.Duration
+		method       string
+		body         string
+		wantStatus   int
+		wantAttempts int
+	}{
+		{
+			name:         "Gives up after max attempts",
+			retry:        &pb.RetryPolicy{MaxAttempts: proto.Int32(3)},
+			status:       http.StatusBadGateway,
+			method:       "GET",
+			wantStatus:   http.StatusBadGateway,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Status not retried",
+			retry:        &pb.RetryPolicy{RetryOn: []int32{http.StatusGatewayTimeout}},
+			status:       http.StatusBadGateway,
+			method:       "GET",
+			wantStatus:   http.StatusBadGateway,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Post not retried by default",
+			retry:        &pb.RetryPolicy{},
+			status:       http.StatusServiceUnavailable,
+			method:       "POST",
+			body:         "hello",
+			wantStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Post retried with its body",
+			retry:        &pb.RetryPolicy{Methods: []string{"post"}},
+			status:       http.StatusServiceUnavailable,
+			method:       "POST",
+			body:         "hello",
+			wantStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 2,
+		},
+		{
+			name:         "Body too large",
+			retry:        &pb.RetryPolicy{Methods: []string{"POST"}, MaxBodyBytes: proto.Int64(3)},
+			status:       http.StatusServiceUnavailable,
+			method:       "POST",
+			body:         "hello",
+			wantStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Per try timeout",
+			retry:        &pb.RetryPolicy{PerTryTimeout: durationpb.New(200 * time.Millisecond)},
+			status:       http.StatusOK,
+			delay:        time.Second,
+			method:       "GET",
+			wantStatus:   http.StatusGatewayTimeout,
+			wantAttempts: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			be := newCountingBackend(test.status, test.delay)
+			defer be.server.Close()
+			pool := retryPool(t, test.retry, be.server.URL)
+
+			resp := httptest.NewRecorder()
+			pool.ServeHTTP(resp, httptest.NewRequest(test.method, "/", strings.NewReader(test.body)))
+
+			if resp.Code != test.wantStatus {
+				t.Errorf("want status %v, got %v", test.wantStatus, resp.Code)
+			}
+			// Closing waits for the attempts the backend is still serving.
+			be.server.Close()
+			bodies := be.received()
+			if len(bodies) != test.wantAttempts {
+				t.Fatalf("want %v attempts, got %v", test.wantAttempts, len(bodies))
+			}
+			for _, body := range bodies {
+				if body != test.body {
+					t.Errorf("want body %q, got %q", test.body, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryStopsWithoutBackends(t *testing.T) {
+	be := newCountingBackend(http.StatusServiceUnavailable, 0)
+	defer be.server.Close()
+	// The breaker opens on the first failure, so no backend is left to retry on.
+	pool, err := newBackendPool("retried", pb.BalancingAlgorithm_RoundRobin, &pb.BackendConfig{
+		Type:           &pb.BackendConfig_Static{Static: &pb.StaticBackends{Urls: []string{be.server.URL}}},
+		Retry:          &pb.RetryPolicy{MaxAttempts: proto.Int32(3)},
+		CircuitBreaker: &pb.CircuitBreaker{ConsecutiveFailures: proto.Int32(1)},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	pool.lbAlgo.(backendLookup).Lookup(be.server.URL).SetAlive(true)
+
+	resp := httptest.NewRecorder()
+	pool.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+
+	if resp.Code != http.StatusServiceUnavailable || resp.Body.String() != "Service Unavailable" {
+		t.Errorf("want the backend response, got %v %q", resp.Code, resp.Body.String())
+	}
+	if got := len(be.received()); got != 1 {
+		t.Errorf("want 1 attempt, got %v", got)
+	}
+	if got := pool.retry.budget.retried; got != 0 {
+		t.Errorf("want no retries charged to the budget, got %v", got)
+	}
+}
+
+func TestNewRetryPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry *pb.RetryPolicy
+	}{
+		{name: "No attempts", retry: &pb.RetryPolicy{MaxAttempts: proto.Int32(0)}},
+		{name: "Invalid status", retry: &pb.RetryPolicy{RetryOn: []int32{42}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newRetryPolicy(test.retry); err == nil {
+				t.Errorf("newRetryPolicy() want error, got nil")
+			}
+		})
+	}
+}
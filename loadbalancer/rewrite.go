@@ -0,0 +1,131 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+// replacePrefix replaces the route prefix of path with replacement,
+// keeping a single slash between the replacement and the rest of path.
+func replacePrefix(path, prefix, replacement string) string {
+	rest := strings.TrimPrefix(path, prefix)
+	if len(rest) == 0 {
+		if len(replacement) == 0 {
+			return "/"
+		}
+		return replacement
+	}
+	return strings.TrimSuffix(replacement, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
+
+// rewrite changes the path and host of the requests of a route.
+type rewrite struct {
+	// prefix replaces the route prefix if set, regex is used otherwise.
+	prefix       *string
+	regex        *regexp.Regexp
+	substitution string
+	host         string
+}
+
+func newRewrite(rewriteCfg *pb.Rewrite) (*rewrite, error) {
+	rw := &rewrite{
+		host: rewriteCfg.GetHost(),
+	}
+	switch path := rewriteCfg.GetPath().(type) {
+	case *pb.Rewrite_Prefix:
+		rw.prefix = &path.Prefix
+	case *pb.Rewrite_Regex:
+		regex, err := regexp.Compile(path.Regex.GetPattern())
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite regex: %v", err)
+		}
+		rw.regex = regex
+		rw.substitution = path.Regex.GetSubstitution()
+	}
+	return rw, nil
+}
+
+// apply returns a copy of r rewritten for a route with routePrefix.
+func (rw *rewrite) apply(r *http.Request, routePrefix string) *http.Request {
+	rewritten := r.Clone(r.Context())
+	if rw.prefix != nil {
+		rewritten.URL.Path = replacePrefix(r.URL.Path, routePrefix, *rw.prefix)
+		rewritten.URL.RawPath = ""
+	} else if rw.regex != nil {
+		rewritten.URL.Path = rw.regex.ReplaceAllString(r.URL.Path, rw.substitution)
+		rewritten.URL.RawPath = ""
+	}
+	if len(rw.host) > 0 {
+		rewritten.Host = rw.host
+	}
+	return rewritten
+}
+
+// redirect answers the requests of a route with a redirect to another URL.
+type redirect struct {
+	status int
+	scheme string
+	host   string
+	// Either path or prefix is set, or neither to keep the request path.
+	path       *string
+	prefix     *string
+	stripQuery bool
+}
+
+func newRedirect(redirectCfg *pb.Redirect) (*redirect, error) {
+	rd := &redirect{
+		status:     http.StatusFound,
+		scheme:     redirectCfg.GetScheme(),
+		host:       redirectCfg.GetHost(),
+		stripQuery: redirectCfg.GetStripQuery(),
+	}
+	if redirectCfg.Status != nil {
+		switch status := int(redirectCfg.GetStatus()); status {
+		case http.StatusMovedPermanently, http.StatusFound,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			rd.status = status
+		default:
+			return nil, fmt.Errorf("redirect status must be 301, 302, 307 or 308, got %v", status)
+		}
+	}
+	switch path := redirectCfg.GetPath().(type) {
+	case *pb.Redirect_PathReplacement:
+		rd.path = &path.PathReplacement
+	case *pb.Redirect_Prefix:
+		rd.prefix = &path.Prefix
+	}
+	return rd, nil
+}
+
+// location returns the URL a request for a route with routePrefix
+// is redirected to.
+func (rd *redirect) location(r *http.Request, routePrefix string) string {
+	target := *r.URL
+	target.Scheme = "http"
+	if r.TLS != nil {
+		target.Scheme = "https"
+	}
+	target.Host = r.Host
+	if len(rd.scheme) > 0 {
+		target.Scheme = rd.scheme
+	}
+	if len(rd.host) > 0 {
+		target.Host = rd.host
+	}
+	if rd.path != nil {
+		target.Path = *rd.path
+		target.RawPath = ""
+	} else if rd.prefix != nil {
+		target.Path = replacePrefix(r.URL.Path, routePrefix, *rd.prefix)
+		target.RawPath = ""
+	}
+	if rd.stripQuery {
+		target.RawQuery = ""
+	}
+	target.Fragment = ""
+	return target.String()
+}
@@ -0,0 +1,223 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReplacePrefix(t *testing.T) {
+	tests := []struct {
+		path        string
+		prefix      string
+		replacement string
+		want        string
+	}{
+		{path: "/api/users", prefix: "/api", replacement: "/", want: "/users"},
+		{path: "/api/users", prefix: "/api", replacement: "", want: "/users"},
+		{path: "/api", prefix: "/api", replacement: "/", want: "/"},
+		{path: "/api", prefix: "/api", replacement: "", want: "/"},
+		{path: "/api/users", prefix: "/api", replacement: "/v2", want: "/v2/users"},
+		{path: "/api", prefix: "/api", replacement: "/v2", want: "/v2"},
+		{path: "/static/logo.png", prefix: "/static/", replacement: "/assets/", want: "/assets/logo.png"},
+		{path: "/users", prefix: "/", replacement: "/app", want: "/app/users"},
+	}
+
+	for _, test := range tests {
+		if got := replacePrefix(test.path, test.prefix, test.replacement); got != test.want {
+			t.Errorf("replacePrefix(%q, %q, %q) want %q, got %q",
+				test.path, test.prefix, test.replacement, test.want, got)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrite  *pb.Rewrite
+		path     string
+		wantPath string
+		wantHost string
+	}{
+		{
+			name:     "Strips the prefix",
+			rewrite:  &pb.Rewrite{Path: &pb.Rewrite_Prefix{Prefix: "/"}},
+			path:     "/api/users?page=2",
+			wantPath: "/users",
+			wantHost: "lb.example.com",
+		},
+		{
+			name: "Regex with capture groups",
+			rewrite: &pb.Rewrite{Path: &pb.Rewrite_Regex{Regex: &pb.RegexRewrite{
+				Pattern:      proto.String("^/api/users/([0-9]+)$"),
+				Substitution: proto.String("/accounts/$1/profile"),
+			}}},
+			path:     "/api/users/42",
+			wantPath: "/accounts/42/profile",
+			wantHost: "lb.example.com",
+		},
+		{
+			name:     "Host only",
+			rewrite:  &pb.Rewrite{Host: proto.String("users.internal")},
+			path:     "/api/users",
+			wantPath: "/api/users",
+			wantHost: "users.internal",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw, err := newRewrite(test.rewrite)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			req := httptest.NewRequest("GET", "http://lb.example.com"+test.path, nil)
+			origPath := req.URL.Path
+			got := rw.apply(req, "/api")
+			if got.URL.Path != test.wantPath || got.Host != test.wantHost {
+				t.Errorf("apply() want %v%v, got %v%v", test.wantHost, test.wantPath, got.Host, got.URL.Path)
+			}
+			if req.URL.Path != origPath || req.Host != "lb.example.com" {
+				t.Errorf("apply() changed the original request to %v%v", req.Host, req.URL.Path)
+			}
+		})
+	}
+}
+
+func TestRewriteIsProxied(t *testing.T) {
+	var gotPath, gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotHost = r.URL.Path, r.Host
+	}))
+	defer backend.Close()
+
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{
+			Type: &pb.BackendConfig_Static{
+				Static: &pb.StaticBackends{Urls: []string{backend.URL}},
+			},
+		},
+		Routes: []*pb.Route{
+			{
+				PathPrefix: proto.String("/api"),
+				Pool:       proto.String(defaultPool),
+				Rewrite: &pb.Rewrite{
+					Path: &pb.Rewrite_Prefix{Prefix: "/"},
+					Host: proto.String("users.internal"),
+				},
+			},
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	lb.defaultPool.lbAlgo.(backendLookup).Lookup(backend.URL).SetAlive(true)
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users", nil))
+	if gotPath != "/users" || gotHost != "users.internal" {
+		t.Errorf("backend want users.internal/users, got %v%v", gotHost, gotPath)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirect     *pb.Redirect
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "Defaults to 302 on the same URL",
+			redirect:     &pb.Redirect{Scheme: proto.String("https")},
+			target:       "http://example.com/old/page?id=1",
+			wantStatus:   http.StatusFound,
+			wantLocation: "https://example.com/old/page?id=1",
+		},
+		{
+			name: "Permanent redirect to a new prefix",
+			redirect: &pb.Redirect{
+				Status: proto.Int32(http.StatusMovedPermanently),
+				Host:   proto.String("new.example.com"),
+				Path:   &pb.Redirect_Prefix{Prefix: "/new"},
+			},
+			target:       "http://example.com/old/page?id=1",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "http://new.example.com/new/page?id=1",
+		},
+		{
+			name: "Replaces the whole path and drops the query",
+			redirect: &pb.Redirect{
+				Status:     proto.Int32(http.StatusPermanentRedirect),
+				Path:       &pb.Redirect_PathReplacement{PathReplacement: "/home"},
+				StripQuery: proto.Bool(true),
+			},
+			target:       "http://example.com/old/page?id=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "http://example.com/home",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rtr, err := newRouter([]*pb.Route{
+				{PathPrefix: proto.String("/old"), Redirect: test.redirect},
+			}, map[string]*backendPool{}, defaultPool)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			req := httptest.NewRequest("GET", test.target, nil)
+			resp := httptest.NewRecorder()
+			rtr.match(req).ServeHTTP(resp, req)
+			if resp.Code != test.wantStatus {
+				t.Errorf("want status %v, got %v", test.wantStatus, resp.Code)
+			}
+			if got := resp.Header().Get("Location"); got != test.wantLocation {
+				t.Errorf("want location %v, got %v", test.wantLocation, got)
+			}
+		})
+	}
+}
+
+func TestRedirectErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		route *pb.Route
+	}{
+		{
+			name: "Invalid status",
+			route: &pb.Route{
+				Redirect: &pb.Redirect{Status: proto.Int32(http.StatusOK)},
+			},
+		},
+		{
+			name: "Redirect and pool",
+			route: &pb.Route{
+				Pool:     proto.String("api"),
+				Redirect: &pb.Redirect{},
+			},
+		},
+		{
+			name: "Invalid rewrite regex",
+			route: &pb.Route{
+				Pool: proto.String("api"),
+				Rewrite: &pb.Rewrite{Path: &pb.Rewrite_Regex{Regex: &pb.RegexRewrite{
+					Pattern: proto.String("(unclosed"),
+				}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pools := map[string]*backendPool{"api": {name: "api"}}
+			if _, err := newRoute(test.route, pools); err == nil {
+				t.Errorf("newRoute() want error, got nil")
+			}
+		})
+	}
+}
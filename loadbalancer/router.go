@@ -0,0 +1,238 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+type route struct {
+	name        string
+	prefix      string
+	methods     map[string]bool
+	headers     []*valueMatcher
+	queryParams []*valueMatcher
+	// Exactly one of pool, split, redirect and direct is set.
+	pool       *backendPool
+	split      *trafficSplit
+	redirect   *redirect
+	direct     *directResponse
+	mirror     *mirror
+	rewrite    *rewrite
+	errorPages *errorPages
+}
+
+func newRoute(routeCfg *pb.Route, pools map[string]*backendPool) (*route, error) {
+	prefix := routeCfg.GetPathPrefix()
+	if len(prefix) == 0 {
+		prefix = "/"
+	} else if !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("route prefix %q must start with /", prefix)
+	}
+	rt := &route{
+		name:   routeCfg.GetName(),
+		prefix: prefix,
+	}
+	targets := 0
+	for _, set := range []bool{
+		routeCfg.Pool != nil, routeCfg.GetSplit() != nil,
+		routeCfg.GetRedirect() != nil, routeCfg.GetDirectResponse() != nil,
+	} {
+		if set {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return nil, fmt.Errorf("route %v must have only one of a pool, a split, a redirect or a direct response", prefix)
+	}
+	if routeCfg.GetDirectResponse() != nil {
+		direct, err := newDirectResponse(routeCfg.GetDirectResponse())
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.direct = direct
+	} else if routeCfg.GetRedirect() != nil {
+		redirect, err := newRedirect(routeCfg.GetRedirect())
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.redirect = redirect
+	} else if routeCfg.GetSplit() != nil {
+		split, err := newTrafficSplit(routeCfg.GetSplit(), pools)
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.split = split
+	} else if pool, present := pools[routeCfg.GetPool()]; present {
+		rt.pool = pool
+	} else {
+		return nil, fmt.Errorf("route %v uses unknown pool %q", prefix, routeCfg.GetPool())
+	}
+	errorPages, err := newErrorPages(routeCfg.GetErrorPages())
+	if err != nil {
+		return nil, fmt.Errorf("route %v: %v", prefix, err)
+	}
+	rt.errorPages = errorPages
+	if routeCfg.GetRewrite() != nil {
+		rewrite, err := newRewrite(routeCfg.GetRewrite())
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.rewrite = rewrite
+	}
+	if routeCfg.GetMirror() != nil {
+		mirror, err := newMirror(routeCfg.GetMirror(), pools)
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.mirror = mirror
+	}
+	if len(routeCfg.GetMethods()) > 0 {
+		rt.methods = make(map[string]bool)
+		for _, method := range routeCfg.GetMethods() {
+			rt.methods[strings.ToUpper(method)] = true
+		}
+	}
+	for _, headerCfg := range routeCfg.GetHeaders() {
+		matcher, err := newValueMatcher(headerCfg)
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.headers = append(rt.headers, matcher)
+	}
+	for _, queryCfg := range routeCfg.GetQueryParams() {
+		matcher, err := newValueMatcher(queryCfg)
+		if err != nil {
+			return nil, fmt.Errorf("route %v: %v", prefix, err)
+		}
+		rt.queryParams = append(rt.queryParams, matcher)
+	}
+	return rt, nil
+}
+
+// conditions counts the conditions of the route besides its path prefix.
+func (rt *route) conditions() int {
+	count := len(rt.headers) + len(rt.queryParams)
+	if rt.methods != nil {
+		count++
+	}
+	return count
+}
+
+// target returns the pool the request goes to, nil for redirects
+// and direct responses.
+func (rt *route) target(r *http.Request) *backendPool {
+	if rt.split != nil {
+		return rt.split.pick(r)
+	}
+	return rt.pool
+}
+
+// ServeHTTP answers the request directly, redirects it, or rewrites it
+// and sends it to the pool of the route, and a copy of it to the mirror
+// pool if any.
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.direct != nil {
+		rt.direct.ServeHTTP(w, r)
+		return
+	} else if rt.redirect != nil {
+		http.Redirect(w, r, rt.redirect.location(r, rt.prefix), rt.redirect.status)
+		return
+	}
+	if rt.rewrite != nil {
+		r = rt.rewrite.apply(r, rt.prefix)
+	}
+	if rt.mirror != nil {
+		defer rt.mirror.copy(r)()
+	}
+	rt.target(r).ServeHTTP(w, r)
+}
+
+// matchesPath reports if path starts with the prefix of the route,
+// on a path segment boundary.
+func (rt *route) matchesPath(path string) bool {
+	if !strings.HasPrefix(path, rt.prefix) {
+		return false
+	}
+	return len(path) == len(rt.prefix) ||
+		strings.HasSuffix(rt.prefix, "/") ||
+		path[len(rt.prefix)] == '/'
+}
+
+// matches reports if the request meets all the conditions of the route.
+func (rt *route) matches(r *http.Request) bool {
+	if !rt.matchesPath(r.URL.Path) {
+		return false
+	} else if rt.methods != nil && !rt.methods[r.Method] {
+		return false
+	}
+	for _, header := range rt.headers {
+		if !header.matchesHeader(r.Header) {
+			return false
+		}
+	}
+	if len(rt.queryParams) == 0 {
+		return true
+	}
+	query := r.URL.Query()
+	for _, param := range rt.queryParams {
+		if !param.matchesQuery(query) {
+			return false
+		}
+	}
+	return true
+}
+
+// router picks the backend pool of a request by its path.
+type router struct {
+	// routes are sorted by decreasing prefix length and then by decreasing
+	// number of conditions, so the first matching route is the most specific.
+	routes []*route
+	// defaultRoute sends the requests not matched by any route to the
+	// default pool. It is nil if there is no default pool.
+	defaultRoute *route
+}
+
+// newRouter routes the requests not matched by any route to the pool
+// named fallback, if it exists.
+func newRouter(routesCfg []*pb.Route, pools map[string]*backendPool, fallback string) (*router, error) {
+	rtr := &router{}
+	if pool, present := pools[fallback]; present {
+		rtr.defaultRoute = &route{prefix: "/", pool: pool}
+	}
+	unconditional := make(map[string]bool)
+	for _, routeCfg := range routesCfg {
+		rt, err := newRoute(routeCfg, pools)
+		if err != nil {
+			return nil, err
+		}
+		if rt.conditions() == 0 {
+			if unconditional[rt.prefix] {
+				return nil, fmt.Errorf("duplicate route prefix %v", rt.prefix)
+			}
+			unconditional[rt.prefix] = true
+		}
+		rtr.routes = append(rtr.routes, rt)
+	}
+	sort.SliceStable(rtr.routes, func(i, j int) bool {
+		if len(rtr.routes[i].prefix) != len(rtr.routes[j].prefix) {
+			return len(rtr.routes[i].prefix) > len(rtr.routes[j].prefix)
+		}
+		return rtr.routes[i].conditions() > rtr.routes[j].conditions()
+	})
+	return rtr, nil
+}
+
+// match returns the most specific matching route, the default route
+// if no route matches, or nil if there is no default route either.
+func (rtr *router) match(r *http.Request) *route {
+	for _, rt := range rtr.routes {
+		if rt.matches(r) {
+			return rt
+		}
+	}
+	return rtr.defaultRoute
+}
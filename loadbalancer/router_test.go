@@ -0,0 +1,200 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// route returns the pool the request goes to, or nil if there is none.
+func (rtr *router) route(r *http.Request) *backendPool {
+	if rt := rtr.match(r); rt != nil {
+		return rt.target(r)
+	}
+	return nil
+}
+
+func TestRouter(t *testing.T) {
+	pools := map[string]*backendPool{
+		defaultPool: {name: defaultPool},
+		"api":       {name: "api"},
+		"users":     {name: "users"},
+		"static":    {name: "static"},
+	}
+	routes := []*pb.Route{
+		{PathPrefix: proto.String("/api"), Pool: proto.String("api")},
+		{PathPrefix: proto.String("/api/users"), Pool: proto.String("users")},
+		{PathPrefix: proto.String("/static/"), Pool: proto.String("static")},
+	}
+	rtr, err := newRouter(routes, pools, defaultPool)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		wantPool string
+	}{
+		{path: "/api", wantPool: "api"},
+		{path: "/api/orders", wantPool: "api"},
+		{path: "/apis", wantPool: defaultPool},
+		{path: "/api/users", wantPool: "users"},
+		{path: "/api/users/42", wantPool: "users"},
+		{path: "/static/logo.png", wantPool: "static"},
+		{path: "/static", wantPool: defaultPool},
+		{path: "/", wantPool: defaultPool},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			got := rtr.route(httptest.NewRequest("GET", test.path, nil))
+			if got.name != test.wantPool {
+				t.Errorf("route(%v) want pool %v, got %v", test.path, test.wantPool, got.name)
+			}
+		})
+	}
+}
+
+func TestRouterConditions(t *testing.T) {
+	pools := map[string]*backendPool{
+		defaultPool: {name: defaultPool},
+		"canary":    {name: "canary"},
+		"upload":    {name: "upload"},
+		"search":    {name: "search"},
+	}
+	routes := []*pb.Route{
+		{
+			PathPrefix: proto.String("/upload"),
+			Pool:       proto.String("upload"),
+			Methods:    []string{"post", "PUT"},
+		},
+		{
+			Pool: proto.String("canary"),
+			Headers: []*pb.ValueMatcher{
+				{Name: proto.String("x-canary"), Match: &pb.ValueMatcher_Exact{Exact: "true"}},
+			},
+		},
+		{
+			Pool: proto.String("search"),
+			QueryParams: []*pb.ValueMatcher{
+				{Name: proto.String("q")},
+			},
+			Headers: []*pb.ValueMatcher{
+				{Name: proto.String("X-Canary"), Match: &pb.ValueMatcher_Present{Present: false}},
+			},
+		},
+	}
+	rtr, err := newRouter(routes, pools, defaultPool)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		headers  map[string]string
+		wantPool string
+	}{
+		{name: "Method matches", method: "POST", target: "/upload", wantPool: "upload"},
+		{name: "Method does not match", method: "GET", target: "/upload", wantPool: defaultPool},
+		{
+			name:     "Longer prefix wins over more conditions",
+			method:   "PUT",
+			target:   "/upload?q=cats",
+			headers:  map[string]string{"X-Canary": "true"},
+			wantPool: "upload",
+		},
+		{
+			name:     "Header matches",
+			method:   "GET",
+			target:   "/",
+			headers:  map[string]string{"X-Canary": "true"},
+			wantPool: "canary",
+		},
+		{
+			name:     "Header value does not match",
+			method:   "GET",
+			target:   "/",
+			headers:  map[string]string{"X-Canary": "false"},
+			wantPool: defaultPool,
+		},
+		{name: "Query parameter matches", method: "GET", target: "/?q=cats", wantPool: "search"},
+		{
+			name:     "Next route when a header must be absent",
+			method:   "GET",
+			target:   "/?q=cats",
+			headers:  map[string]string{"X-Canary": "true"},
+			wantPool: "canary",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+			if got := rtr.route(req); got.name != test.wantPool {
+				t.Errorf("route() want pool %v, got %v", test.wantPool, got.name)
+			}
+		})
+	}
+}
+
+func TestRouterWithoutDefaultPool(t *testing.T) {
+	pools := map[string]*backendPool{
+		"api": {name: "api"},
+	}
+	routes := []*pb.Route{
+		{PathPrefix: proto.String("/api"), Pool: proto.String("api")},
+	}
+	rtr, err := newRouter(routes, pools, defaultPool)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := rtr.route(httptest.NewRequest("GET", "/other", nil)); got != nil {
+		t.Errorf("route(/other) want no pool, got %v", got.name)
+	}
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	pools := map[string]*backendPool{
+		"api": {name: "api"},
+	}
+	tests := []struct {
+		name   string
+		routes []*pb.Route
+	}{
+		{
+			name: "Prefix without leading slash",
+			routes: []*pb.Route{
+				{PathPrefix: proto.String("api"), Pool: proto.String("api")},
+			},
+		},
+		{
+			name: "Duplicate prefix",
+			routes: []*pb.Route{
+				{PathPrefix: proto.String("/api"), Pool: proto.String("api")},
+				{PathPrefix: proto.String("/api"), Pool: proto.String("api")},
+			},
+		},
+		{
+			name: "Unknown pool",
+			routes: []*pb.Route{
+				{PathPrefix: proto.String("/api"), Pool: proto.String("missing")},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newRouter(test.routes, pools, defaultPool); err == nil {
+				t.Errorf("newRouter() want error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// trafficSplit divides the requests of a route between backend pools,
+// proportionally to weights that can change at runtime.
+type trafficSplit struct {
+	// pools keep the order of the config, so that sticky clients only
+	// move between pools whose weights change.
+	pools []*backendPool
+	// hash is nil for splits that pick the pool at random.
+	hash func(r *http.Request) uint64
+	// intn returns a random number in [0, n), replaceable for tests.
+	intn    func(n int) int
+	mu      sync.RWMutex
+	weights []int
+	total   int
+}
+
+func newTrafficSplit(splitCfg *pb.TrafficSplit, pools map[string]*backendPool) (*trafficSplit, error) {
+	ts := &trafficSplit{
+		intn: rand.Intn,
+	}
+	for _, weighted := range splitCfg.GetPools() {
+		pool, present := pools[weighted.GetPool()]
+		if !present {
+			return nil, fmt.Errorf("traffic split uses unknown pool %q", weighted.GetPool())
+		}
+		for _, other := range ts.pools {
+			if other == pool {
+				return nil, fmt.Errorf("duplicate pool %v in traffic split", pool.name)
+			}
+		}
+		ts.pools = append(ts.pools, pool)
+	}
+	if len(ts.pools) < 2 {
+		return nil, fmt.Errorf("traffic splits need at least two pools")
+	}
+	if splitCfg.GetStickyBy() != nil {
+		hash, err := algos.NewRequestHash(splitCfg.GetStickyBy())
+		if err != nil {
+			return nil, err
+		}
+		ts.hash = hash
+	}
+	if err := ts.setWeights(splitCfg.GetPools()); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// setWeights replaces the weights of all the pools of the split at once.
+func (ts *trafficSplit) setWeights(weightedPools []*pb.WeightedPool) error {
+	byName := make(map[string]int32)
+	for _, weighted := range weightedPools {
+		if weighted.GetWeight() < 0 {
+			return fmt.Errorf("pool %v has negative weight %v", weighted.GetPool(), weighted.GetWeight())
+		}
+		byName[weighted.GetPool()] = weighted.GetWeight()
+	}
+	if len(byName) != len(ts.pools) || len(weightedPools) != len(ts.pools) {
+		return fmt.Errorf("traffic split weights must be set once for each of its pools")
+	}
+
+	weights := make([]int, len(ts.pools))
+	total := 0
+	for i, pool := range ts.pools {
+		weight, present := byName[pool.name]
+		if !present {
+			return fmt.Errorf("missing weight for pool %v", pool.name)
+		}
+		weights[i] = int(weight)
+		total += int(weight)
+	}
+	if total == 0 {
+		return fmt.Errorf("at least a pool of the traffic split must have a positive weight")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.weights = weights
+	ts.total = total
+	return nil
+}
+
+// pick returns the pool of the request, by its hash for sticky splits.
+func (ts *trafficSplit) pick(r *http.Request) *backendPool {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var target int
+	if ts.hash != nil {
+		target = int(ts.hash(r) % uint64(ts.total))
+	} else {
+		target = ts.intn(ts.total)
+	}
+	for i, weight := range ts.weights {
+		if target < weight {
+			return ts.pools[i]
+		}
+		target -= weight
+	}
+	return ts.pools[len(ts.pools)-1]
+}
+
+// SetSplit changes the weights of the traffic split of a route.
+func (s *Server) SetSplit(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received split request")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	}
+	splitReq := &pb.SplitRequest{}
+	if err := proto.Unmarshal(body, splitReq); err != nil {
+		log.Printf("Failed to parse split request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error reading request"))
+		return
+	}
+
+	split, present := s.splits[splitReq.GetRoute()]
+	if !present {
+		log.Printf("Received split request for unknown route %q", splitReq.GetRoute())
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown route"))
+		return
+	}
+	if err := split.setWeights(splitReq.GetPools()); err != nil {
+		log.Printf("Invalid split request for route %v: %v", splitReq.GetRoute(), err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error handling split"))
+		return
+	}
+	log.Printf("Route %v is now split as %v", splitReq.GetRoute(), splitReq.GetPools())
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Split updated"))
+}
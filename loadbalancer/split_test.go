@@ -0,0 +1,215 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func weightedPools(weights map[string]int32) []*pb.WeightedPool {
+	var res []*pb.WeightedPool
+	for _, name := range []string{"stable", "canary"} {
+		if weight, present := weights[name]; present {
+			res = append(res, &pb.WeightedPool{Pool: proto.String(name), Weight: proto.Int32(weight)})
+		}
+	}
+	return res
+}
+
+func splitPools() map[string]*backendPool {
+	return map[string]*backendPool{
+		"stable": {name: "stable"},
+		"canary": {name: "canary"},
+	}
+}
+
+func TestTrafficSplit(t *testing.T) {
+	split, err := newTrafficSplit(&pb.TrafficSplit{
+		Pools: weightedPools(map[string]int32{"stable": 95, "canary": 5}),
+	}, splitPools())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	got := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		split.intn = func(n int) int { return i % n }
+		got[split.pick(httptest.NewRequest("GET", "/", nil)).name]++
+	}
+	if got["stable"] != 95 || got["canary"] != 5 {
+		t.Errorf("want 95 stable and 5 canary requests, got %v", got)
+	}
+}
+
+func TestStickyTrafficSplit(t *testing.T) {
+	split, err := newTrafficSplit(&pb.TrafficSplit{
+		Pools:    weightedPools(map[string]int32{"stable": 90, "canary": 10}),
+		StickyBy: &pb.HashPolicy{Source: pb.HashPolicy_HEADER.Enum(), Name: proto.String("X-User")},
+	}, splitPools())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	pickAll := func() []string {
+		var picks []string
+		for i := 0; i < 1000; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", fmt.Sprintf("user-%v", i))
+			picks = append(picks, split.pick(req).name)
+		}
+		return picks
+	}
+
+	before := pickAll()
+	if again := pickAll(); fmt.Sprint(before) != fmt.Sprint(again) {
+		t.Errorf("want clients to stay on their pool")
+	}
+
+	if err := split.setWeights(weightedPools(map[string]int32{"stable": 50, "canary": 50})); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	canaries := 0
+	for i, pool := range pickAll() {
+		if pool == "canary" {
+			canaries++
+		} else if before[i] == "canary" {
+			t.Errorf("user-%v moved from canary back to stable when canary grew", i)
+		}
+	}
+	if canaries < 400 || canaries > 600 {
+		t.Errorf("want about half of the clients on canary, got %v", canaries)
+	}
+}
+
+func TestTrafficSplitErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		split *pb.TrafficSplit
+	}{
+		{
+			name:  "Single pool",
+			split: &pb.TrafficSplit{Pools: weightedPools(map[string]int32{"stable": 1})},
+		},
+		{
+			name: "Unknown pool",
+			split: &pb.TrafficSplit{Pools: []*pb.WeightedPool{
+				{Pool: proto.String("stable"), Weight: proto.Int32(1)},
+				{Pool: proto.String("missing"), Weight: proto.Int32(1)},
+			}},
+		},
+		{
+			name:  "Negative weight",
+			split: &pb.TrafficSplit{Pools: weightedPools(map[string]int32{"stable": 1, "canary": -1})},
+		},
+		{
+			name:  "All weights zero",
+			split: &pb.TrafficSplit{Pools: weightedPools(map[string]int32{"stable": 0, "canary": 0})},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newTrafficSplit(test.split, splitPools()); err == nil {
+				t.Errorf("newTrafficSplit() want error, got nil")
+			}
+		})
+	}
+}
+
+func TestSetSplit(t *testing.T) {
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{},
+		Pools: []*pb.BackendPool{
+			{Name: proto.String("stable"), Backend: &pb.BackendConfig{}},
+			{Name: proto.String("canary"), Backend: &pb.BackendConfig{}},
+		},
+		Routes: []*pb.Route{
+			{
+				Name:       proto.String("checkout"),
+				PathPrefix: proto.String("/checkout"),
+				Split: &pb.TrafficSplit{
+					Pools: weightedPools(map[string]int32{"stable": 100, "canary": 0}),
+				},
+			},
+		},
+		SplitPath: proto.String("/split"),
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		req        *pb.SplitRequest
+		wantStatus int
+		wantPool   string
+	}{
+		{
+			name:       "Unknown route",
+			req:        &pb.SplitRequest{Route: proto.String("cart")},
+			wantStatus: http.StatusNotFound,
+			wantPool:   "stable",
+		},
+		{
+			name: "Missing pool",
+			req: &pb.SplitRequest{
+				Route: proto.String("checkout"),
+				Pools: weightedPools(map[string]int32{"canary": 100}),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantPool:   "stable",
+		},
+		{
+			name: "Moves all traffic to canary",
+			req: &pb.SplitRequest{
+				Route: proto.String("checkout"),
+				Pools: weightedPools(map[string]int32{"stable": 0, "canary": 100}),
+			},
+			wantStatus: http.StatusOK,
+			wantPool:   "canary",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := proto.Marshal(test.req)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			resp := httptest.NewRecorder()
+			lb.SetSplit(resp, httptest.NewRequest("POST", "/split", bytes.NewBuffer(body)))
+			if resp.Code != test.wantStatus {
+				t.Errorf("SetSplit() want status %v, got %v", test.wantStatus, resp.Code)
+			}
+			got := lb.router.route(httptest.NewRequest("GET", "/checkout", nil))
+			if got.name != test.wantPool {
+				t.Errorf("route(/checkout) want pool %v, got %v", test.wantPool, got.name)
+			}
+		})
+	}
+}
+
+func TestDuplicateRouteNames(t *testing.T) {
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{},
+		Routes: []*pb.Route{
+			{Name: proto.String("api"), PathPrefix: proto.String("/api"), Pool: proto.String(defaultPool)},
+		},
+		VirtualHosts: []*pb.VirtualHost{
+			{
+				Domains: []string{"example.com"},
+				Routes: []*pb.Route{
+					{Name: proto.String("api"), PathPrefix: proto.String("/v2"), Pool: proto.String(defaultPool)},
+				},
+			},
+		},
+	}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("New() want error for duplicate route names, got nil")
+	}
+}
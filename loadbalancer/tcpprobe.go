@@ -0,0 +1,84 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+)
+
+const defaultTCPProbeTimeout = 5 * time.Second
+
+// tcpProbeAddress returns the host:port a TCP probe connects to, the port
+// of the probe if set, or else the one of the backend URL or its scheme.
+func tcpProbeAddress(rawURL string, port int32) (string, error) {
+	beURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	} else if len(beURL.Hostname()) == 0 {
+		return "", fmt.Errorf("backend %v has no host", rawURL)
+	}
+	switch {
+	case port > 0:
+		return net.JoinHostPort(beURL.Hostname(), strconv.Itoa(int(port))), nil
+	case len(beURL.Port()) > 0:
+		return beURL.Host, nil
+	case beURL.Scheme == "https":
+		return net.JoinHostPort(beURL.Hostname(), "443"), nil
+	default:
+		return net.JoinHostPort(beURL.Hostname(), "80"), nil
+	}
+}
+
+// tcpAlive checks if the backend accepts a TCP connection and, if the
+// probe has an exchange, answers what the probe expects.
+func tcpAlive(ctx context.Context, be *algos.Backend, probe *pb.TcpConnect) bool {
+	addr, err := tcpProbeAddress(be.URL(), probe.GetPort())
+	if err != nil {
+		log.Printf("Error getting the probe address of %v: %v, will consider the backend down", be.URL(), err)
+		return false
+	}
+	timeout := defaultTCPProbeTimeout
+	if probe.GetTimeout() != nil {
+		timeout = probe.GetTimeout().AsDuration()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		log.Printf("%v is unreachable, error: %v", addr, err)
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if len(probe.GetSend()) > 0 {
+		if _, err := conn.Write(probe.GetSend()); err != nil {
+			log.Printf("Error sending the probe to %v: %v", addr, err)
+			return false
+		}
+	}
+	if len(probe.GetExpect()) > 0 {
+		answer := make([]byte, len(probe.GetExpect()))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			log.Printf("Error reading the probe answer of %v: %v", addr, err)
+			return false
+		} else if !bytes.Equal(answer, probe.GetExpect()) {
+			log.Printf("Received unexpected probe answer from %v: %q", addr, answer)
+			return false
+		}
+	}
+	return true
+}
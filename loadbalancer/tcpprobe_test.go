@@ -0,0 +1,132 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/flo_lb/loadbalancer/algos"
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// lineServer answers each line it reads with answer, if set,
+// or just accepts the connections otherwise.
+func lineServer(t *testing.T, answer string) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+					if len(answer) > 0 {
+						conn.Write([]byte(answer))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func TestTCPProbeAddress(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		port   int32
+		want   string
+	}{
+		{rawURL: "http://localhost:6379", want: "localhost:6379"},
+		{rawURL: "http://localhost:8080", port: 9090, want: "localhost:9090"},
+		{rawURL: "http://localhost", want: "localhost:80"},
+		{rawURL: "https://example.com", want: "example.com:443"},
+		{rawURL: "http://[::1]:6379", want: "[::1]:6379"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rawURL, func(t *testing.T) {
+			got, err := tcpProbeAddress(test.rawURL, test.port)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			} else if got != test.want {
+				t.Errorf("tcpProbeAddress(%v, %v) want %v, got %v", test.rawURL, test.port, test.want, got)
+			}
+		})
+	}
+}
+
+func TestTCPAlive(t *testing.T) {
+	pong := lineServer(t, "+PONG\r\n")
+	silent := lineServer(t, "")
+	closed := lineServer(t, "")
+	closed.Close()
+
+	tests := []struct {
+		name  string
+		addr  string
+		probe *pb.TcpConnect
+		want  bool
+	}{
+		{
+			name:  "Connects",
+			addr:  silent.Addr().String(),
+			probe: &pb.TcpConnect{},
+			want:  true,
+		},
+		{
+			name:  "Refused",
+			addr:  closed.Addr().String(),
+			probe: &pb.TcpConnect{},
+			want:  false,
+		},
+		{
+			name:  "Expected answer",
+			addr:  pong.Addr().String(),
+			probe: &pb.TcpConnect{Send: []byte("PING\r\n"), Expect: []byte("+PONG")},
+			want:  true,
+		},
+		{
+			name:  "Unexpected answer",
+			addr:  pong.Addr().String(),
+			probe: &pb.TcpConnect{Send: []byte("PING\r\n"), Expect: []byte("+OK")},
+			want:  false,
+		},
+		{
+			name: "No answer before the timeout",
+			addr: silent.Addr().String(),
+			probe: &pb.TcpConnect{
+				Send:    []byte("PING\r\n"),
+				Expect:  []byte("+PONG"),
+				Timeout: durationpb.New(50 * time.Millisecond),
+			},
+			want: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			be, err := algos.NewBackend(fmt.Sprintf("http://%v", test.addr))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got := tcpAlive(context.Background(), be, test.probe); got != test.want {
+				t.Errorf("tcpAlive() want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type wildcardHost[T any] struct {
+	// suffix is the wildcard domain without its leading "*", e.g. ".example.com".
+	suffix string
+	value  T
+}
+
+// hostMatcher finds the value configured for a host name, looking for
+// an exact match first and then for the longest matching wildcard.
+type hostMatcher[T any] struct {
+	exact map[string]T
+	// wildcards are sorted by decreasing suffix length, so the first
+	// matching wildcard is the most specific one.
+	wildcards []*wildcardHost[T]
+}
+
+func newHostMatcher[T any]() *hostMatcher[T] {
+	return &hostMatcher[T]{
+		exact: make(map[string]T),
+	}
+}
+
+// normalizeHost lowercases a host name and strips its port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func (m *hostMatcher[T]) add(domain string, value T) error {
+	domain = normalizeHost(domain)
+	if len(domain) == 0 {
+		return fmt.Errorf("virtual host domains must not be empty")
+	}
+	if !strings.HasPrefix(domain, "*") {
+		if _, present := m.exact[domain]; present {
+			return fmt.Errorf("duplicate virtual host domain %v", domain)
+		}
+		m.exact[domain] = value
+		return nil
+	}
+
+	suffix := domain[1:]
+	if !strings.HasPrefix(suffix, ".") || len(suffix) == 1 || strings.Contains(suffix, "*") {
+		return fmt.Errorf("wildcard domain %v must look like *.example.com", domain)
+	}
+	for _, wildcard := range m.wildcards {
+		if wildcard.suffix == suffix {
+			return fmt.Errorf("duplicate virtual host domain %v", domain)
+		}
+	}
+	m.wildcards = append(m.wildcards, &wildcardHost[T]{suffix: suffix, value: value})
+	sort.SliceStable(m.wildcards, func(i, j int) bool {
+		return len(m.wildcards[i].suffix) > len(m.wildcards[j].suffix)
+	})
+	return nil
+}
+
+// match returns the value of the most specific domain matching host.
+func (m *hostMatcher[T]) match(host string) (T, bool) {
+	host = normalizeHost(host)
+	if value, present := m.exact[host]; present {
+		return value, true
+	}
+	for _, wildcard := range m.wildcards {
+		if len(host) > len(wildcard.suffix) && strings.HasSuffix(host, wildcard.suffix) {
+			return wildcard.value, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// requestHost is the host a request is for, from its Host header or,
+// for the clients that don't send one, from the TLS SNI.
+func requestHost(r *http.Request) string {
+	if len(r.Host) == 0 && r.TLS != nil {
+		return r.TLS.ServerName
+	}
+	return r.Host
+}
@@ -0,0 +1,278 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/FlorinBalint/flo_lb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHostMatcher(t *testing.T) {
+	matcher := newHostMatcher[string]()
+	for domain, value := range map[string]string{
+		"example.com":        "apex",
+		"WWW.example.com":    "www",
+		"*.example.com":      "wildcard",
+		"*.blog.example.com": "blog",
+	} {
+		if err := matcher.add(domain, value); err != nil {
+			t.Fatalf("unexpected error adding %v: %v", domain, err)
+		}
+	}
+
+	tests := []struct {
+		host   string
+		want   string
+		wantOK bool
+	}{
+		{host: "example.com", want: "apex", wantOK: true},
+		{host: "example.com:8080", want: "apex", wantOK: true},
+		{host: "www.Example.com.", want: "www", wantOK: true},
+		{host: "shop.example.com", want: "wildcard", wantOK: true},
+		{host: "a.b.example.com", want: "wildcard", wantOK: true},
+		{host: "me.blog.example.com", want: "blog", wantOK: true},
+		{host: "blog.example.com", want: "wildcard", wantOK: true},
+		{host: "notexample.com"},
+		{host: "other.org"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			got, gotOK := matcher.match(test.host)
+			if got != test.want || gotOK != test.wantOK {
+				t.Errorf("match(%v) want (%q, %v), got (%q, %v)", test.host, test.want, test.wantOK, got, gotOK)
+			}
+		})
+	}
+}
+
+func TestHostMatcherErrors(t *testing.T) {
+	for _, domains := range [][]string{
+		{""},
+		{"*"},
+		{"*example.com"},
+		{"*.*.example.com"},
+		{"example.com", "Example.com"},
+		{"*.example.com", "*.EXAMPLE.com"},
+	} {
+		matcher := newHostMatcher[bool]()
+		var err error
+		for _, domain := range domains {
+			if err = matcher.add(domain, true); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("adding %q want error, got nil", domains)
+		}
+	}
+}
+
+func TestVirtualHostRouting(t *testing.T) {
+	backends := map[string]*testBackend{
+		"default": alwaysAliveBackend(),
+		"blog":    alwaysAliveBackend(),
+		"shop":    alwaysAliveBackend(),
+		"api":     alwaysAliveBackend(),
+	}
+	for _, be := range backends {
+		be.startListen(t)
+		defer be.stop(t)
+	}
+	staticBackend := func(name string) *pb.BackendConfig {
+		return &pb.BackendConfig{
+			Type: &pb.BackendConfig_Static{
+				Static: &pb.StaticBackends{Urls: []string{backends[name].server.URL}},
+			},
+		}
+	}
+
+	cfg := &pb.Config{
+		Name:    proto.String("Test LB"),
+		Backend: staticBackend("default"),
+		Pools: []*pb.BackendPool{
+			{Name: proto.String("blog"), Backend: staticBackend("blog")},
+			{Name: proto.String("shop"), Backend: staticBackend("shop")},
+			{Name: proto.String("api"), Backend: staticBackend("api")},
+		},
+		VirtualHosts: []*pb.VirtualHost{
+			{
+				Domains:     []string{"blog.example.com"},
+				DefaultPool: proto.String("blog"),
+			},
+			{
+				Domains:     []string{"*.shop.example.com", "shop.example.com"},
+				DefaultPool: proto.String("shop"),
+				Routes: []*pb.Route{
+					{PathPrefix: proto.String("/api"), Pool: proto.String("api")},
+				},
+			},
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	defer lb.Close()
+	for name, pool := range lb.pools {
+		pool.lbAlgo.(backendLookup).Lookup(backends[name].server.URL).SetAlive(true)
+	}
+
+	tests := []struct {
+		host        string
+		path        string
+		wantBackend string
+	}{
+		{host: "blog.example.com", path: "/posts", wantBackend: "blog"},
+		{host: "shop.example.com:443", path: "/cart", wantBackend: "shop"},
+		{host: "eu.shop.example.com", path: "/api/items", wantBackend: "api"},
+		{host: "other.org", path: "/", wantBackend: "default"},
+		{host: "blog.example.com", path: "/api/items", wantBackend: "blog"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host+test.path, func(t *testing.T) {
+			before := make(map[string]int32)
+			for name, be := range backends {
+				before[name] = atomic.LoadInt32(&be.requestsReceived)
+			}
+			req := httptest.NewRequest("GET", test.path, nil)
+			req.Host = test.host
+			resp := httptest.NewRecorder()
+			lb.ServeHTTP(resp, req)
+
+			for name, be := range backends {
+				got := atomic.LoadInt32(&be.requestsReceived) - before[name]
+				want := int32(0)
+				if name == test.wantBackend {
+					want = 1
+				}
+				if got != want {
+					t.Errorf("backend %v got %v requests, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVirtualHostUnknownDefaultPool(t *testing.T) {
+	cfg := &pb.Config{
+		Backend: &pb.BackendConfig{},
+		VirtualHosts: []*pb.VirtualHost{
+			{
+				Domains:     []string{"example.com"},
+				DefaultPool: proto.String("missing"),
+			},
+		},
+	}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("New() want error for an unknown default pool, got nil")
+	}
+}
+
+// selfSignedCert writes a certificate for commonName and its key to dir.
+func selfSignedCert(t *testing.T, dir string, commonName string) *pb.LocalCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshalling key: %v", err)
+	}
+
+	certPath := filepath.Join(dir, commonName+".crt")
+	keyPath := filepath.Join(dir, commonName+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey})
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+	return &pb.LocalCert{
+		CertPath:       proto.String(certPath),
+		PrivateKeyPath: proto.String(keyPath),
+	}
+}
+
+func TestVirtualHostCerts(t *testing.T) {
+	dir := t.TempDir()
+	localhostCert := selfSignedCert(t, dir, "localhost")
+	wildcardCert := selfSignedCert(t, dir, "*.example.com")
+	cfg := &pb.Config{
+		Backend:  &pb.BackendConfig{},
+		Protocol: pb.Protocol_HTTPS.Enum(),
+		Cert: &pb.CertConfig{
+			CertSource: &pb.CertConfig_Local{Local: localhostCert},
+		},
+		VirtualHosts: []*pb.VirtualHost{
+			{
+				Domains: []string{"*.example.com"},
+				Cert: &pb.CertConfig{
+					CertSource: &pb.CertConfig_Local{Local: wildcardCert},
+				},
+			},
+		},
+	}
+	lb, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating LB: %v", err)
+	}
+	if err := lb.SetupTLS(context.Background()); err != nil {
+		t.Fatalf("Error setting up TLS: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       *pb.LocalCert
+	}{
+		{serverName: "www.example.com", want: wildcardCert},
+		{serverName: "localhost", want: localhostCert},
+		{serverName: "", want: localhostCert},
+	}
+
+	for _, test := range tests {
+		t.Run(test.serverName, func(t *testing.T) {
+			want, err := tls.LoadX509KeyPair(test.want.GetCertPath(), test.want.GetPrivateKeyPath())
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			got, err := lb.server.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: test.serverName})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !bytes.Equal(got.Certificate[0], want.Certificate[0]) {
+				t.Errorf("GetCertificate(%q) did not return %v", test.serverName, test.want.GetCertPath())
+			}
+		})
+	}
+}